@@ -0,0 +1,191 @@
+package crawler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Thresholds used by the audit. They follow what search engines show in
+// their result pages rather than any hard rule.
+const (
+	maxTitleLength       = 60
+	maxDescriptionLength = 160
+	minWordCount         = 300
+)
+
+// AuditIssue is one kind of problem and the pages that have it.
+type AuditIssue struct {
+	ID          string       `json:"id"`
+	Description string       `json:"description"`
+	Count       int          `json:"count"`
+	URLs        []string     `json:"urls"`
+	Groups      []AuditGroup `json:"groups,omitempty"`
+}
+
+// AuditGroup lists pages sharing the same value, for duplicate checks.
+type AuditGroup struct {
+	Value string   `json:"value"`
+	URLs  []string `json:"urls"`
+}
+
+// AuditReport is the outcome of auditing a crawl: every issue found and
+// how many pages were checked.
+type AuditReport struct {
+	PagesAudited int          `json:"pages_audited"`
+	Issues       []AuditIssue `json:"issues"`
+}
+
+// audit checks every successfully fetched HTML page for common SEO
+// problems. Issues with no affected pages are left out of the report.
+func (cfg *config) audit() AuditReport {
+	cfg.resultMu.Lock()
+	defer cfg.resultMu.Unlock()
+
+	pages := map[string]*Page{}
+	inlinks := map[string]int{}
+	err := cfg.results.each(func(key string, page *Page) bool {
+		for _, link := range page.Links {
+			if target := normalizeURL(link); target != key {
+				inlinks[target]++
+			}
+		}
+		if page.Err == "" && page.StatusCode == 200 {
+			pages[reportURL(page)] = page
+		}
+		return true
+	})
+	if err != nil {
+		cfg.logger.Error("error reading pages", "error", err)
+	}
+
+	urls := make([]string, 0, len(pages))
+	for u := range pages {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+
+	collect := func(id, description string, match func(page *Page) bool) AuditIssue {
+		issue := AuditIssue{ID: id, Description: description, URLs: []string{}}
+		for _, u := range urls {
+			if match(pages[u]) {
+				issue.URLs = append(issue.URLs, u)
+			}
+		}
+		issue.Count = len(issue.URLs)
+		return issue
+	}
+
+	duplicates := func(id, description string, value func(page *Page) string) AuditIssue {
+		byValue := map[string][]string{}
+		for _, u := range urls {
+			if v := value(pages[u]); v != "" {
+				byValue[v] = append(byValue[v], u)
+			}
+		}
+		issue := AuditIssue{ID: id, Description: description, URLs: []string{}}
+		for v, group := range byValue {
+			if len(group) > 1 {
+				issue.Groups = append(issue.Groups, AuditGroup{Value: v, URLs: group})
+				issue.URLs = append(issue.URLs, group...)
+			}
+		}
+		sort.Strings(issue.URLs)
+		sort.Slice(issue.Groups, func(i, j int) bool {
+			return issue.Groups[i].Value < issue.Groups[j].Value
+		})
+		issue.Count = len(issue.URLs)
+		return issue
+	}
+
+	title := func(page *Page) string { return page.Meta.Title }
+	description := func(page *Page) string { return page.Meta.Description }
+
+	issues := []AuditIssue{
+		collect("missing_title", "Pages without a <title>", func(page *Page) bool {
+			return page.Meta.Title == ""
+		}),
+		duplicates("duplicate_title", "Pages sharing the same title", title),
+		collect("title_too_long", fmt.Sprintf("Titles longer than %d characters", maxTitleLength), func(page *Page) bool {
+			return utf8.RuneCountInString(page.Meta.Title) > maxTitleLength
+		}),
+		collect("missing_description", "Pages without a meta description", func(page *Page) bool {
+			return page.Meta.Description == ""
+		}),
+		duplicates("duplicate_description", "Pages sharing the same meta description", description),
+		collect("description_too_long", fmt.Sprintf("Meta descriptions longer than %d characters", maxDescriptionLength), func(page *Page) bool {
+			return utf8.RuneCountInString(page.Meta.Description) > maxDescriptionLength
+		}),
+		collect("missing_h1", "Pages without an <h1>", func(page *Page) bool {
+			return page.Meta.H1Count == 0
+		}),
+		collect("multiple_h1", "Pages with more than one <h1>", func(page *Page) bool {
+			return page.Meta.H1Count > 1
+		}),
+		collect("thin_content", fmt.Sprintf("Pages with fewer than %d words", minWordCount), func(page *Page) bool {
+			return page.Meta.WordCount < minWordCount
+		}),
+		collect("noindex_linked", "Noindex pages that are linked internally", func(page *Page) bool {
+			return page.NoIndex && inlinks[normalizeURL(reportURL(page))] > 0
+		}),
+		collect("canonical_mismatch", "Pages whose canonical URL points elsewhere", func(page *Page) bool {
+			return !page.isCanonical()
+		}),
+	}
+
+	report := AuditReport{PagesAudited: len(pages), Issues: []AuditIssue{}}
+	for _, issue := range issues {
+		if issue.Count > 0 {
+			report.Issues = append(report.Issues, issue)
+		}
+	}
+	return report
+}
+
+// reportURL is the URL a page is reported under: where it ended up after
+// redirects, or where it was found when it was never fetched.
+func reportURL(page *Page) string {
+	if page.FinalURL != "" {
+		return page.FinalURL
+	}
+	return page.URL
+}
+
+// WriteText writes the audit as a plain-text report.
+func (report AuditReport) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "\n\n\n=============================\n")
+	fmt.Fprintf(w, "AUDIT of %d pages\n", report.PagesAudited)
+	fmt.Fprintf(w, "=============================\n")
+
+	if len(report.Issues) == 0 {
+		fmt.Fprintf(w, "\nNo issues found\n")
+		return
+	}
+
+	for _, issue := range report.Issues {
+		fmt.Fprintf(w, "\n%s (%d)\n", issue.Description, issue.Count)
+		fmt.Fprintf(w, "%s\n", strings.Repeat("-", len(issue.Description)+len(fmt.Sprint(issue.Count))+3))
+		if len(issue.Groups) > 0 {
+			for _, group := range issue.Groups {
+				fmt.Fprintf(w, "%q\n", group.Value)
+				for _, u := range group.URLs {
+					fmt.Fprintf(w, "    %s\n", u)
+				}
+			}
+			continue
+		}
+		for _, u := range issue.URLs {
+			fmt.Fprintf(w, "%s\n", u)
+		}
+	}
+}
+
+// WriteJSON writes the audit as indented JSON.
+func (report AuditReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
@@ -0,0 +1,98 @@
+package crawler
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func auditPage(url string, meta PageMetadata) *Page {
+	return &Page{URL: url, FinalURL: url, StatusCode: 200, ContentType: "text/html", Meta: meta}
+}
+
+func TestAudit(t *testing.T) {
+	c := newConfig([]string{"https://example.com"}, 1, 10)
+	good := PageMetadata{
+		Title:       "Home",
+		Description: "The home page",
+		H1:          []string{"Welcome"},
+		H1Count:     1,
+		WordCount:   500,
+	}
+
+	home := auditPage("https://example.com", good)
+	home.Links = []string{"https://example.com/hidden", "https://example.com/a"}
+	c.results.put("example.com", home)
+
+	a := good
+	a.Title = "Shared title"
+	a.Description = strings.Repeat("d", 200)
+	a.H1 = []string{"One", "Two"}
+	a.H1Count = 2
+	c.results.put("example.com/a", auditPage("https://example.com/a", a))
+
+	b := good
+	b.Title = "Shared title"
+	b.Description = ""
+	b.H1 = nil
+	b.H1Count = 0
+	b.WordCount = 10
+	c.results.put("example.com/b", auditPage("https://example.com/b", b))
+
+	hidden := good
+	hidden.Title = strings.Repeat("t", 61)
+	hidden.Description = "Hidden"
+	hiddenPage := auditPage("https://example.com/hidden", hidden)
+	hiddenPage.NoIndex = true
+	hiddenPage.Canonical = "https://example.com/"
+	c.results.put("example.com/hidden", hiddenPage)
+
+	c.results.put("example.com/broken", &Page{URL: "https://example.com/broken", StatusCode: 404, Err: "404 Not Found"})
+
+	report := c.audit()
+	if report.PagesAudited != 4 {
+		t.Errorf("expected 4 audited pages, got %d", report.PagesAudited)
+	}
+
+	got := map[string][]string{}
+	for _, issue := range report.Issues {
+		if issue.Count != len(issue.URLs) {
+			t.Errorf("%s: count %d does not match %d URLs", issue.ID, issue.Count, len(issue.URLs))
+		}
+		got[issue.ID] = issue.URLs
+	}
+	want := map[string][]string{
+		"duplicate_title":      {"https://example.com/a", "https://example.com/b"},
+		"title_too_long":       {"https://example.com/hidden"},
+		"missing_description":  {"https://example.com/b"},
+		"description_too_long": {"https://example.com/a"},
+		"missing_h1":           {"https://example.com/b"},
+		"multiple_h1":          {"https://example.com/a"},
+		"thin_content":         {"https://example.com/b"},
+		"noindex_linked":       {"https://example.com/hidden"},
+		"canonical_mismatch":   {"https://example.com/hidden"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("audit issues\n  got: %v\n want: %v", got, want)
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteJSON(&buf); err != nil {
+		t.Fatalf("unexpected error writing JSON: %v", err)
+	}
+	var decoded AuditReport
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("audit JSON does not decode: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, report) {
+		t.Errorf("JSON round trip changed the report")
+	}
+
+	buf.Reset()
+	report.WriteText(&buf)
+	if !strings.Contains(buf.String(), "Pages sharing the same title (2)") || !strings.Contains(buf.String(), `"Shared title"`) {
+		t.Errorf("unexpected text audit:\n%s", buf.String())
+	}
+}
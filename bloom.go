@@ -0,0 +1,159 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// errCountsUnavailable is returned by seen-sets that only remember whether
+// a URL was seen, not how many links pointed at it.
+var errCountsUnavailable = errors.New("seen-set does not keep link counts")
+
+// bloomSeenSet is an approximate seen-set. It uses a fixed amount of
+// memory, sized up front from the expected number of URLs and the target
+// false-positive rate, at the price of occasionally taking a new URL for
+// one already seen (which is then skipped). It never forgets a URL.
+// It is safe for concurrent use: bits are set with atomic OR, and visits
+// of the same URL take the same shard lock so only one of them adds it.
+type bloomSeenSet struct {
+	bits     []uint64
+	m        uint64       // number of bits
+	k        uint64       // number of hash functions
+	n        atomic.Int64 // URLs added
+	expected int
+	target   float64
+	shards   [64]sync.Mutex
+}
+
+// newBloomSeenSet sizes a filter for expected URLs at false-positive rate
+// fpRate, using the usual m = -n·ln(p)/ln(2)² and k = (m/n)·ln(2).
+func newBloomSeenSet(expected int, fpRate float64) (*bloomSeenSet, error) {
+	if expected <= 0 {
+		return nil, fmt.Errorf("expected URL count must be positive, got %d", expected)
+	}
+	if fpRate <= 0 || fpRate >= 1 {
+		return nil, fmt.Errorf("false-positive rate must be between 0 and 1, got %v", fpRate)
+	}
+
+	m := uint64(math.Ceil(-float64(expected) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	m = max(64, (m+63)/64*64)
+	k := uint64(max(1, math.Round(float64(m)/float64(expected)*math.Ln2)))
+
+	return &bloomSeenSet{
+		bits:     make([]uint64, m/64),
+		m:        m,
+		k:        k,
+		expected: expected,
+		target:   fpRate,
+	}, nil
+}
+
+// bloomHash splits a 128-bit FNV-1a hash of key into the two halves
+// that positions double hashes.
+func bloomHash(key string) (h1, h2 uint64) {
+	h := fnv.New128a()
+	h.Write([]byte(key))
+	sum := h.Sum(nil)
+	for i := range 8 {
+		h1 = h1<<8 | uint64(sum[i])
+		h2 = h2<<8 | uint64(sum[8+i])
+	}
+	return h1, h2 | 1 // an odd step visits distinct positions
+}
+
+// positions calls fn with each of the k bit positions of a key hashed to
+// h1 and h2, stopping early if fn returns false.
+func (b *bloomSeenSet) positions(h1, h2 uint64, fn func(pos uint64) bool) {
+	for i := range b.k {
+		if !fn((h1 + i*h2) % b.m) {
+			return
+		}
+	}
+}
+
+// visit sets the key's bits and reports whether any of them was unset.
+// Two workers visiting the same URL at once would otherwise each set some
+// of its bits and both take it for new.
+func (b *bloomSeenSet) visit(key string) (bool, error) {
+	h1, h2 := bloomHash(key)
+	shard := &b.shards[h1%uint64(len(b.shards))]
+	shard.Lock()
+	defer shard.Unlock()
+
+	added := false
+	b.positions(h1, h2, func(pos uint64) bool {
+		mask := uint64(1) << (pos % 64)
+		if atomic.OrUint64(&b.bits[pos/64], mask)&mask == 0 {
+			added = true
+		}
+		return true
+	})
+	if added {
+		b.n.Add(1)
+	}
+	return added, nil
+}
+
+// add sets the bits of every key; the filter keeps no counts.
+func (b *bloomSeenSet) add(counts map[string]int) error {
+	for key := range counts {
+		b.visit(key)
+	}
+	return nil
+}
+
+func (b *bloomSeenSet) has(key string) (bool, error) {
+	h1, h2 := bloomHash(key)
+	found := true
+	b.positions(h1, h2, func(pos uint64) bool {
+		found = atomic.LoadUint64(&b.bits[pos/64])&(uint64(1)<<(pos%64)) != 0
+		return found
+	})
+	return found, nil
+}
+
+func (b *bloomSeenSet) len() int {
+	return int(b.n.Load())
+}
+
+func (b *bloomSeenSet) each(func(key string, count int) bool) error {
+	return errCountsUnavailable
+}
+
+func (b *bloomSeenSet) close() error {
+	return nil
+}
+
+// falsePositiveRate estimates the chance that an unseen URL is reported
+// as seen, given how many URLs the filter holds now.
+func (b *bloomSeenSet) falsePositiveRate() float64 {
+	n := float64(b.n.Load())
+	return math.Pow(1-math.Exp(-float64(b.k)*n/float64(b.m)), float64(b.k))
+}
+
+// memoryBytes is the size of the bit array.
+func (b *bloomSeenSet) memoryBytes() int {
+	return len(b.bits) * 8
+}
+
+func (b *bloomSeenSet) String() string {
+	return fmt.Sprintf("bloom filter, %d URLs in %s (%d bits, %d hashes), estimated false-positive rate %.4g%% (target %.4g%% at %d URLs)",
+		b.n.Load(), formatBytes(b.memoryBytes()), b.m, b.k, b.falsePositiveRate()*100, b.target*100, b.expected)
+}
+
+func formatBytes(n int) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
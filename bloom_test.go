@@ -0,0 +1,126 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestNewBloomSeenSet_Sizing(t *testing.T) {
+	b, err := newBloomSeenSet(1000000, 0.01)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// About 9.6 bits and 7 hashes per element for a 1% rate.
+	if b.m < 9500000 || b.m > 9700000 || b.k != 7 {
+		t.Errorf("unexpected sizing: m=%d k=%d", b.m, b.k)
+	}
+	if b.memoryBytes() != int(b.m/8) {
+		t.Errorf("memory %d does not match %d bits", b.memoryBytes(), b.m)
+	}
+
+	for _, tc := range []struct {
+		expected int
+		rate     float64
+	}{{0, 0.01}, {10, 0}, {10, 1}} {
+		if _, err := newBloomSeenSet(tc.expected, tc.rate); err == nil {
+			t.Errorf("expected an error for %d URLs at rate %v", tc.expected, tc.rate)
+		}
+	}
+}
+
+func TestBloomSeenSet_FalsePositiveRate(t *testing.T) {
+	const n = 20000
+	b, _ := newBloomSeenSet(n, 0.01)
+
+	for i := range n {
+		key := fmt.Sprintf("example.com/page/%d", i)
+		b.visit(key)
+		if seen, _ := b.has(key); !seen {
+			t.Fatalf("false negative for %s", key)
+		}
+	}
+	if first, _ := b.visit("example.com/page/7"); first {
+		t.Errorf("a repeated URL must never be reported as new")
+	}
+
+	falsePositives := 0
+	for i := range n {
+		if seen, _ := b.has(fmt.Sprintf("other.org/item/%d", i)); seen {
+			falsePositives++
+		}
+	}
+	measured := float64(falsePositives) / n
+	if measured > 0.02 {
+		t.Errorf("measured false-positive rate %.4f is far above the 1%% target", measured)
+	}
+	if estimate := b.falsePositiveRate(); estimate < 0.005 || estimate > 0.015 {
+		t.Errorf("estimated false-positive rate %.4f, want about 0.01", estimate)
+	}
+}
+
+func TestBloomSeenSet_ConcurrentVisits(t *testing.T) {
+	const n = 1000
+	b, _ := newBloomSeenSet(n, 0.001)
+
+	// Every worker visits every URL; each URL must be new to exactly one.
+	var added atomic.Int64
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range n {
+				if first, _ := b.visit(fmt.Sprintf("example.com/page/%d", i)); first {
+					added.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if added.Load() > n {
+		t.Errorf("%d visits reported new for %d URLs; want at most one per URL", added.Load(), n)
+	}
+}
+
+func TestCrawl_BloomSeenSet(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintln(w, createHTML(r.URL.Path, []string{"/a", "/b", "/"}))
+	}))
+	defer server.Close()
+
+	c := newConfig([]string{server.URL}, 2, 100)
+	c.pages, _ = newBloomSeenSet(100, 0.001)
+	c.crawl(context.Background())
+
+	host := normalizeURL(server.URL)
+	if c.results.len() != 3 {
+		t.Fatalf("expected 3 results, got %d", c.results.len())
+	}
+	// Counts come from the stored links: every page links to all three,
+	// and the seed was named once more on the command line.
+	want := map[string]int{host: 4, host + "/a": 3, host + "/b": 3}
+	if counts := c.pageCounts(); !reflect.DeepEqual(counts, want) {
+		t.Errorf("counts = %v; want %v", counts, want)
+	}
+
+	dir := t.TempDir()
+	if err := c.saveCheckpoint(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Without counts, each page is saved as seen once.
+	restored := newConfig(nil, 1, 100)
+	if err := restored.restore(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if restored.pages.len() != 3 {
+		t.Errorf("expected 3 pages in the checkpoint, got %d", restored.pages.len())
+	}
+}
@@ -0,0 +1,72 @@
+package crawler
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/transform"
+)
+
+// charsetSniffSize is how much of a body is looked at to work out its
+// charset. Declarations must come in the first 1024 bytes; the rest
+// helps tell unlabelled UTF-8 from windows-1252.
+const charsetSniffSize = 64 << 10
+
+// detectCharset works out the character encoding of an HTML body from
+// its first bytes, head, using, in order of precedence, a byte order
+// mark, the charset parameter of the Content-Type header and a <meta
+// charset> or http-equiv declaration in the first 1024 bytes. Bodies
+// without any declaration are treated as UTF-8 when head is valid UTF-8
+// and as windows-1252 otherwise, which is what browsers do for
+// ISO-8859-1 labelled or unlabelled pages.
+func detectCharset(head []byte, contentType string) string {
+	_, name, certain := charset.DetermineEncoding(head, contentType)
+	if !certain && name == "windows-1252" && !declaresCharset(head) && utf8.Valid(trimPartialRune(head)) {
+		return "utf-8"
+	}
+	return name
+}
+
+// trimPartialRune drops a UTF-8 sequence cut short at the end of b, as
+// happens when b is the start of a longer body.
+func trimPartialRune(b []byte) []byte {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return b[:i]
+			}
+			break
+		}
+	}
+	return b
+}
+
+// declaresCharset reports whether the prescan window of body mentions a
+// charset at all, in which case the declaration is trusted as-is.
+func declaresCharset(body []byte) bool {
+	if len(body) > 1024 {
+		body = body[:1024]
+	}
+	return bytes.Contains(bytes.ToLower(body), []byte("charset"))
+}
+
+// newUTF8Reader detects the charset of the body read from r and returns
+// a reader transcoding it to UTF-8, along with the charset's name. Bodies
+// in unknown charsets are passed through unchanged.
+func newUTF8Reader(r io.Reader, contentType string) (io.Reader, string, error) {
+	br := bufio.NewReaderSize(r, charsetSniffSize)
+	head, err := br.Peek(charsetSniffSize)
+	if err != nil && err != io.EOF && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, "", err
+	}
+	name := detectCharset(head, contentType)
+	enc, _ := charset.Lookup(name)
+	if enc == nil {
+		return br, name, nil
+	}
+	return transform.NewReader(br, enc.NewDecoder()), name, nil
+}
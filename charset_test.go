@@ -0,0 +1,152 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+)
+
+func encode(t *testing.T, enc encoding.Encoding, s string) []byte {
+	t.Helper()
+	b, err := enc.NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		t.Fatalf("could not encode %q: %v", s, err)
+	}
+	return b
+}
+
+func TestDetectCharset(t *testing.T) {
+	testCases := []struct {
+		name        string
+		body        []byte
+		contentType string
+		expected    string
+	}{
+		{
+			name:        "Header charset",
+			body:        []byte("<html><body>hi</body></html>"),
+			contentType: "text/html; charset=Shift_JIS",
+			expected:    "shift_jis",
+		},
+		{
+			name:        "Meta charset",
+			body:        []byte(`<html><head><meta charset="windows-1251"></head></html>`),
+			contentType: "text/html",
+			expected:    "windows-1251",
+		},
+		{
+			name:        "Meta http-equiv",
+			body:        []byte(`<html><head><meta http-equiv="Content-Type" content="text/html; charset=iso-8859-1"></head></html>`),
+			contentType: "text/html",
+			expected:    "windows-1252", // WHATWG maps iso-8859-1 to windows-1252
+		},
+		{
+			name:        "BOM wins over header",
+			body:        append([]byte{0xEF, 0xBB, 0xBF}, "<html></html>"...),
+			contentType: "text/html; charset=windows-1251",
+			expected:    "utf-8",
+		},
+		{
+			name:        "Undeclared UTF-8",
+			body:        []byte("<html><body>héllo</body></html>"),
+			contentType: "text/html",
+			expected:    "utf-8",
+		},
+		{
+			name:        "Undeclared ASCII",
+			body:        []byte("<html><body>hello</body></html>"),
+			contentType: "text/html",
+			expected:    "utf-8",
+		},
+		{
+			name:        "Undeclared UTF-8 cut mid-character",
+			body:        []byte("<html><body>h\xc3\xa9llo \xe6\x97"),
+			contentType: "text/html",
+			expected:    "utf-8",
+		},
+		{
+			name:        "Undeclared Latin-1",
+			body:        []byte("<html><body>h\xe9llo</body></html>"),
+			contentType: "text/html",
+			expected:    "windows-1252",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := detectCharset(tc.body, tc.contentType)
+			if actual != tc.expected {
+				t.Errorf("detectCharset() = %q; want %q", actual, tc.expected)
+			}
+		})
+	}
+}
+
+func TestFetchPage_Transcoding(t *testing.T) {
+	testCases := []struct {
+		name        string
+		contentType string
+		body        []byte
+		expected    string
+	}{
+		{
+			name:        "Shift_JIS from header",
+			contentType: "text/html; charset=Shift_JIS",
+			body:        encode(t, japanese.ShiftJIS, "<html><head><title>日本語のページ</title></head></html>"),
+			expected:    "日本語のページ",
+		},
+		{
+			name:        "Windows-1251 from meta",
+			contentType: "text/html",
+			body:        encode(t, charmap.Windows1251, `<html><head><meta charset="windows-1251"><title>Привет</title></head></html>`),
+			expected:    "Привет",
+		},
+		{
+			name:        "ISO-8859-1 from header",
+			contentType: "text/html; charset=ISO-8859-1",
+			body:        encode(t, charmap.ISO8859_1, "<html><head><title>Café español</title></head></html>"),
+			expected:    "Café español",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := createMockServer(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tc.contentType)
+				w.Write(tc.body)
+			})
+			defer server.Close()
+
+			res, err := fetchPage(context.Background(), server.URL, defaultFetchLimits, validators{}, &HTTPFetcher{})
+			if err != nil {
+				t.Fatalf("Expected no error, but got: %v", err)
+			}
+			html := readFetched(t, res)
+			if !strings.Contains(html, tc.expected) {
+				t.Errorf("Expected body to contain %q, but got %q", tc.expected, html)
+			}
+		})
+	}
+}
+
+func TestCrawlPage_RecordsCharset(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=windows-1251")
+		w.Write(encode(t, charmap.Windows1251, "<html><body>Привет</body></html>"))
+	}))
+	defer server.Close()
+
+	c := newConfig([]string{server.URL}, 1, 10)
+	c.crawl(context.Background())
+
+	result, _ := c.result(normalizeURL(server.URL))
+	if result == nil || result.Charset != "windows-1251" {
+		t.Errorf("expected the page to be recorded as windows-1251, got %+v", result)
+	}
+}
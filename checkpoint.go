@@ -0,0 +1,390 @@
+package crawler
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+const (
+	checkpointFile    = "checkpoint.ndjson"
+	checkpointResults = "results.ndjson"
+	checkpointVersion = 1
+
+	// restoreBatch is how many seen pages restore adds at a time.
+	restoreBatch = 10000
+)
+
+// A checkpoint is two files of JSON lines. checkpointFile starts with a
+// checkpointHeader and goes on with a checkpointRecord for every queued
+// and every seen page; it is rewritten whole each time. checkpointResults
+// holds a checkpointResult per finished page and only grows: a checkpoint
+// appends the pages stored since the last one, and the header says how
+// much of the file belongs to it.
+type checkpointHeader struct {
+	Version     int       `json:"version"`
+	SavedAt     time.Time `json:"saved_at"`
+	Seeds       []string  `json:"seeds"`
+	ResultsSize int64     `json:"results_size"`
+}
+
+// checkpointRecord is either a page waiting in the frontier or a seen
+// page with its link count.
+type checkpointRecord struct {
+	Queued *crawlItem `json:"queued,omitempty"`
+	Page   string     `json:"page,omitempty"`
+	Count  int        `json:"count,omitempty"`
+}
+
+type checkpointResult struct {
+	Key  string `json:"key"`
+	Page *Page  `json:"page"`
+}
+
+// checkpointLog remembers what the last checkpoint saved, so the next
+// one only has to append the results stored since.
+type checkpointLog struct {
+	dir     string   // where the last checkpoint went, "" before the first
+	size    int64    // length of its results file
+	unsaved []string // keys of the results stored since
+}
+
+// track notes a newly stored result. Before the first checkpoint there
+// is nothing to append to, so nothing is noted.
+func (l *checkpointLog) track(key string) {
+	if l.dir != "" {
+		l.unsaved = append(l.unsaved, key)
+	}
+}
+
+// saveCheckpoint writes the crawl state to dir. Results are appended to
+// what the previous checkpoint in dir wrote; the rest is written under a
+// temporary name and renamed into place, so a crash while saving leaves
+// the previous checkpoint intact. The crawl is paused while saving.
+func (cfg *config) saveCheckpoint(dir string) error {
+	cfg.enqueueMu.Lock()
+	defer cfg.enqueueMu.Unlock()
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cfg.resultMu.Lock()
+	defer cfg.resultMu.Unlock()
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	size, err := cfg.appendResults(dir)
+	if err != nil {
+		return fmt.Errorf("error saving results: %w", err)
+	}
+
+	header := checkpointHeader{
+		Version:     checkpointVersion,
+		SavedAt:     time.Now().UTC(),
+		Seeds:       cfg.seeds,
+		ResultsSize: size,
+	}
+	err = writeFileAtomic(filepath.Join(dir, checkpointFile), func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		if err := enc.Encode(header); err != nil {
+			return err
+		}
+		return cfg.writeRecords(enc)
+	})
+	if err != nil {
+		return fmt.Errorf("error saving crawl state: %w", err)
+	}
+	cfg.saved = checkpointLog{dir: dir, size: size}
+	return nil
+}
+
+// appendResults brings the results file in dir up to date and returns
+// its length. Anything past what the last checkpoint wrote, left by a
+// save that failed halfway, is dropped first. Writing to a new dir
+// starts the file over.
+func (cfg *config) appendResults(dir string) (int64, error) {
+	f, err := os.OpenFile(filepath.Join(dir, checkpointResults), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	full := cfg.saved.dir != dir
+	start := cfg.saved.size
+	if full {
+		start = 0
+	}
+	if err := f.Truncate(start); err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	write := func(key string, page *Page) error {
+		return enc.Encode(checkpointResult{Key: key, Page: page})
+	}
+	if full {
+		err = eachResult(cfg.results, write)
+	} else {
+		for _, key := range cfg.saved.unsaved {
+			page, ok, err := cfg.results.get(key)
+			if err != nil {
+				return 0, err
+			}
+			if ok {
+				if err := write(key, page); err != nil {
+					return 0, err
+				}
+			}
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	if err := f.Sync(); err != nil {
+		return 0, err
+	}
+	return f.Seek(0, io.SeekCurrent)
+}
+
+// writeRecords writes the frontier and the seen pages. Pages being
+// fetched at the moment go first, since their results are not in yet.
+func (cfg *config) writeRecords(enc *json.Encoder) error {
+	queued := func(item crawlItem) error {
+		return enc.Encode(checkpointRecord{Queued: &item})
+	}
+	for key, item := range cfg.inflight {
+		done, err := cfg.results.has(key)
+		if err != nil {
+			return err
+		}
+		if !done {
+			if err := queued(item); err != nil {
+				return err
+			}
+		}
+	}
+	var encErr error
+	err := cfg.queue.each(func(item crawlItem) bool {
+		encErr = queued(item)
+		return encErr == nil
+	})
+	if encErr != nil {
+		return encErr
+	}
+	if err != nil {
+		return err
+	}
+
+	seen := func(key string, count int) bool {
+		encErr = enc.Encode(checkpointRecord{Page: key, Count: count})
+		return encErr == nil
+	}
+	err = cfg.pages.each(seen)
+	if errors.Is(err, errCountsUnavailable) {
+		// Without counts, every page known to be seen is saved once:
+		// those in flight and not done, those queued and those done.
+		for key := range cfg.inflight {
+			done, err := cfg.results.has(key)
+			if err != nil {
+				return err
+			}
+			if !done && !seen(key, 1) {
+				return encErr
+			}
+		}
+		err = cfg.queue.each(func(item crawlItem) bool {
+			return seen(normalizeURL(item.URL), 1)
+		})
+		if err == nil && encErr == nil {
+			err = cfg.results.each(func(key string, _ *Page) bool {
+				return seen(key, 1)
+			})
+		}
+	}
+	if encErr != nil {
+		return encErr
+	}
+	return err
+}
+
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	w := bufio.NewWriter(tmp)
+	if err := write(w); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// Make the rename itself durable.
+	if d, err := os.Open(filepath.Dir(path)); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// restore loads the state saved in dir into a config that has not
+// crawled yet, whatever its storage backend. Seeds given on the command
+// line are added to the saved ones.
+func (cfg *config) restore(dir string) error {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cfg.resultMu.Lock()
+	defer cfg.resultMu.Unlock()
+
+	f, err := os.Open(filepath.Join(dir, checkpointFile))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(bufio.NewReader(f))
+
+	var header checkpointHeader
+	if err := dec.Decode(&header); err != nil {
+		return fmt.Errorf("error decoding checkpoint: %w", err)
+	}
+	if header.Version != checkpointVersion {
+		return fmt.Errorf("unsupported checkpoint version %d", header.Version)
+	}
+
+	seeds := append([]string{}, header.Seeds...)
+	for _, seed := range cfg.seeds {
+		if !slices.Contains(header.Seeds, seed) {
+			seeds = append(seeds, seed)
+		}
+	}
+	if err := checkSeedHosts(seeds); err != nil {
+		return err
+	}
+	cfg.seeds = seeds
+
+	if err := cfg.restoreResults(filepath.Join(dir, checkpointResults), header.ResultsSize); err != nil {
+		return err
+	}
+
+	// Seen pages are restored in batches: one call, and one transaction
+	// on disk, per restoreBatch pages rather than one per saved link.
+	seen := make(map[string]int, restoreBatch)
+	for {
+		var record checkpointRecord
+		if err := dec.Decode(&record); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("error decoding checkpoint: %w", err)
+		}
+		if record.Queued != nil {
+			done, err := cfg.results.has(normalizeURL(record.Queued.URL))
+			if err != nil {
+				return err
+			}
+			if done {
+				continue
+			}
+			if err := cfg.queue.push(*record.Queued); err != nil {
+				return err
+			}
+			cfg.counts.queueHost(record.Queued.URL)
+			continue
+		}
+		seen[record.Page] += record.Count
+		if len(seen) == restoreBatch {
+			if err := cfg.pages.add(seen); err != nil {
+				return err
+			}
+			clear(seen)
+		}
+	}
+	if err := cfg.pages.add(seen); err != nil {
+		return err
+	}
+
+	cfg.saved = checkpointLog{dir: dir, size: header.ResultsSize}
+	cfg.resumed = true
+	return nil
+}
+
+// restoreResults stores the first size bytes worth of results saved in
+// path; anything after them was not part of the checkpoint.
+func (cfg *config) restoreResults(path string, size int64) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) && size == 0 {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < size {
+		return fmt.Errorf("%s holds %d bytes of results; the checkpoint needs %d", path, info.Size(), size)
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(io.LimitReader(f, size)))
+	for {
+		var result checkpointResult
+		if err := dec.Decode(&result); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("error decoding results: %w", err)
+		}
+		if err := cfg.results.put(result.Key, result.Page); err != nil {
+			return err
+		}
+		cfg.counts.count(result.Page)
+	}
+}
+
+// checkpointEvery saves the crawl state to dir on every tick until stop
+// is closed.
+func (cfg *config) checkpointEvery(dir string, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := cfg.saveCheckpoint(dir); err != nil {
+				cfg.logger.Error("error saving checkpoint", "dir", dir, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestCheckpoint_SaveAndRestore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	c := newConfig([]string{"https://example.com"}, 1, 10)
+	c.enqueue("https://example.com", 0)
+	c.enqueue("https://example.com/a", 1)
+	c.enqueue("https://example.com/a", 1)
+
+	item, _ := c.next(context.Background()) // the seed is now being fetched
+	c.storeResult("example.com/done", &Page{URL: "https://example.com/done", StatusCode: 200, Meta: PageMetadata{Title: "Done"}})
+
+	if err := c.saveCheckpoint(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 || entries[0].Name() != checkpointFile || entries[1].Name() != checkpointResults {
+		t.Errorf("expected only %s and %s in the state dir, got %v", checkpointFile, checkpointResults, entries)
+	}
+
+	restored := newConfig(nil, 1, 10)
+	if err := restored.restore(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(restored.seeds, []string{"https://example.com"}) {
+		t.Errorf("unexpected seeds: %v", restored.seeds)
+	}
+	var frontier []crawlItem
+	restored.queue.each(func(item crawlItem) bool {
+		frontier = append(frontier, item)
+		return true
+	})
+	wantFrontier := []crawlItem{item, {URL: "https://example.com/a", Depth: 1, Seed: "https://example.com"}}
+	if !reflect.DeepEqual(frontier, wantFrontier) {
+		t.Errorf("frontier\n  got: %v\n want: %v", frontier, wantFrontier)
+	}
+	pages := map[string]int{}
+	restored.pages.each(func(key string, count int) bool {
+		pages[key] = count
+		return true
+	})
+	if !reflect.DeepEqual(pages, map[string]int{"example.com": 1, "example.com/a": 2}) {
+		t.Errorf("unexpected pages: %v", pages)
+	}
+	if page, ok := restored.result("example.com/done"); !ok || page.Meta.Title != "Done" {
+		t.Errorf("results were not restored: %v", page)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, checkpointFile), []byte("{broken"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := newConfig(nil, 1, 10).restore(dir); err == nil {
+		t.Errorf("expected an error for a corrupt checkpoint")
+	}
+}
+
+func TestCheckpoint_AppendsResults(t *testing.T) {
+	dir := t.TempDir()
+	resultsPath := filepath.Join(dir, checkpointResults)
+	c := newConfig([]string{"https://example.com"}, 1, 10)
+	store := func(path string) {
+		c.storeResult("example.com"+path, &Page{URL: "https://example.com" + path, StatusCode: 200})
+	}
+
+	store("/a")
+	if err := c.saveCheckpoint(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	first, _ := os.ReadFile(resultsPath)
+
+	// Leftovers of a save that died halfway must be dropped.
+	f, err := os.OpenFile(resultsPath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"key":"example.com/half`)
+	f.Close()
+
+	store("/b")
+	store("/c")
+	if err := c.saveCheckpoint(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, _ := os.ReadFile(resultsPath)
+	if !bytes.HasPrefix(second, first) {
+		t.Errorf("the second checkpoint rewrote the results of the first")
+	}
+	if lines := bytes.Count(second, []byte("\n")); lines != 3 {
+		t.Errorf("results file has %d lines; want 3:\n%s", lines, second)
+	}
+
+	// A checkpoint in another dir holds every result.
+	other := t.TempDir()
+	if err := c.saveCheckpoint(other); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(other, checkpointResults)); !bytes.Equal(data, second) {
+		t.Errorf("results in the new dir\n  got: %s\n want: %s", data, second)
+	}
+
+	restored := newConfig(nil, 1, 10)
+	if err := restored.restore(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if restored.results.len() != 3 {
+		t.Errorf("restored %d results; want 3", restored.results.len())
+	}
+}
+
+func TestCheckpoint_Resume(t *testing.T) {
+	var mu sync.Mutex
+	hits := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprintln(w, createHTML("Index", []string{"/a", "/b"}))
+		case "/a":
+			fmt.Fprintln(w, createHTML("A", []string{"/c", "/"}))
+		default:
+			fmt.Fprintln(w, createHTML(r.URL.Path, nil))
+		}
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+
+	// Fetch only the seed, then stop as if the process had been killed.
+	first := newConfig([]string{server.URL}, 1, 100)
+	first.enqueue(server.URL, 0)
+	item, _ := first.next(context.Background())
+	first.crawlPage(context.Background(), item, first.logger)
+	first.finish(item, false)
+	if err := first.saveCheckpoint(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	second := newConfig(nil, 2, 100)
+	if err := second.restore(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second.crawl(context.Background())
+
+	host := normalizeURL(server.URL)
+	for _, key := range []string{host, host + "/a", host + "/b", host + "/c"} {
+		if _, ok := second.result(key); !ok {
+			t.Errorf("expected %s in the resumed results", key)
+		}
+	}
+	if counts := second.pageCounts(); counts[host] != 2 {
+		t.Errorf("expected 2 links to the seed after resuming, got %d", counts[host])
+	}
+	for path, count := range hits {
+		if count != 1 {
+			t.Errorf("%s was fetched %d times", path, count)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	tests := []struct {
+		name      string
+		level     string
+		format    string
+		wantErr   bool
+		wantDebug bool
+		wantJSON  bool
+	}{
+		{name: "text info", level: "info", format: "text"},
+		{name: "json debug", level: "debug", format: "json", wantDebug: true, wantJSON: true},
+		{name: "upper case level", level: "WARN", format: "text"},
+		{name: "unknown level", level: "loud", format: "text", wantErr: true},
+		{name: "unknown format", level: "info", format: "xml", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			logger, err := newLogger(&out, tc.level, tc.format)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			logger.Debug("fetching page", "url", "https://example.com/")
+			if got := out.Len() > 0; got != tc.wantDebug {
+				t.Errorf("debug line logged = %v, want %v", got, tc.wantDebug)
+			}
+			out.Reset()
+
+			logger.Error("fetch failed", "url", "https://example.com/", "status", 500)
+			line := strings.TrimSpace(out.String())
+			if tc.wantJSON {
+				var record map[string]any
+				if err := json.Unmarshal([]byte(line), &record); err != nil {
+					t.Fatalf("line %q is not JSON: %v", line, err)
+				}
+				if record["url"] != "https://example.com/" || record["level"] != "ERROR" {
+					t.Errorf("record = %v", record)
+				}
+			} else if !strings.Contains(line, "url=https://example.com/ status=500") {
+				t.Errorf("line %q lacks the attributes", line)
+			}
+		})
+	}
+}
@@ -0,0 +1,341 @@
+// Command crawler crawls websites from the command line and reports on
+// what it found. See the crawler package for the library behind it.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/vladimirck/crawler"
+)
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		os.Exit(runDiff(os.Args[2:]))
+	}
+	os.Exit(run())
+}
+
+// run crawls as the command line asks and returns the exit status. It
+// leaves exiting to main so that deferred cleanup, such as closing the
+// crawler and its stores, always happens.
+func run() int {
+	output := flag.String("output", "report", "what to produce after the crawl: report, sitemap, audit or duplicates")
+	format := flag.String("format", "text", "format of the audit or duplicates report: text or json")
+	sitemapFile := flag.String("sitemap-file", "sitemap.xml", "path of the sitemap (or sitemap index) to write")
+	sitemapBaseURL := flag.String("sitemap-base-url", "", "public URL where the sitemap files are served (defaults to the base URL)")
+	sitemapPriority := flag.String("sitemap-priority", crawler.PriorityNone, "how to fill <priority>: none, depth or pagerank")
+	seedsFile := flag.String("seeds-file", "", "file with one seed URL per line, crawled in addition to the seeds on the command line")
+	maxBodySize := flag.Int64("max-body-size", 10<<20, "maximum bytes read per response, 0 for no limit")
+	bodyLimitMode := flag.String("body-limit-mode", "truncate", "what to do with bodies over -max-body-size: truncate or reject")
+	maxDecompressed := flag.Int64("max-decompressed-size", 50<<20, "maximum bytes a compressed response may expand to, 0 for no limit")
+	maxRatio := flag.Float64("max-compression-ratio", 100, "maximum decompressed to compressed size ratio, 0 for no limit")
+	ignoreRobotsMeta := flag.Bool("ignore-robots-meta", false, "follow links on nofollow pages and list noindex pages in sitemaps")
+	stateDir := flag.String("state-dir", "", "directory where crawl checkpoints are saved")
+	checkpointInterval := flag.Duration("checkpoint-interval", crawler.DefaultCheckpointInterval, "how often to save a checkpoint when -state-dir is set")
+	resumeDir := flag.String("resume", "", "resume the crawl checkpointed in this directory")
+	storeBackend := flag.String("store", crawler.StoreMemory, "where to keep the frontier, seen-set and crawled pages: memory or disk")
+	storeDir := flag.String("store-dir", "", "directory for the disk store, empty or new (defaults to a temporary directory)")
+	seenMode := flag.String("seen", "exact", "how to remember seen URLs: exact, or bloom for a fixed-size approximate filter")
+	expectedURLs := flag.Int("expected-urls", 0, "number of URLs the bloom filter is sized for (defaults to maxPages)")
+	fpRate := flag.Float64("fp-rate", 0.001, "target false-positive rate of the bloom filter")
+	streamPath := flag.String("stream", "", "write every page as a line of JSON to this file as soon as it is crawled, - for stdout in place of the report")
+	sqlitePath := flag.String("sqlite", "", "also store pages, links, redirects and errors in this SQLite database")
+	maxSegmentRepeats := flag.Int("max-segment-repeats", crawler.DefaultTrapLimits.MaxSegmentRepeats, "skip URLs repeating one path segment more often than this, 0 for no limit")
+	maxPathDepth := flag.Int("max-path-depth", crawler.DefaultTrapLimits.MaxPathDepth, "skip URLs with more path segments than this, 0 for no limit")
+	maxQueryParams := flag.Int("max-query-params", crawler.DefaultTrapLimits.MaxQueryParams, "skip URLs with more query parameters than this, 0 for no limit")
+	patternBudget := flag.Int("pattern-budget", crawler.DefaultTrapLimits.PatternBudget, "maximum URLs crawled per path pattern (numbers in the path wildcarded), 0 for no limit")
+	similarityThreshold := flag.Float64("similarity", crawler.DefaultSimilarity, "fraction of SimHash bits pages must share to be reported as near-duplicates")
+	warcDir := flag.String("warc-dir", "", "archive every request and response as gzipped WARC 1.1 files in this directory")
+	warcPrefix := flag.String("warc-prefix", "crawl", "file name prefix of the WARC files")
+	warcMaxSize := flag.Int64("warc-max-size", crawler.DefaultWARCMaxSize, "size in bytes at which a new WARC file is started, 0 for no limit")
+	retries := flag.Int("retries", 0, "times to retry a fetch that hits a network error or gets a 429 or 5xx answer")
+	retryBackoff := flag.Duration("retry-backoff", time.Second, "wait before the first retry, doubled after each one")
+	logLevel := flag.String("log-level", "info", "lowest level of the messages logged to stderr: debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "format of the messages logged to stderr: text or json")
+	progress := flag.Bool("progress", true, "show live progress on stderr when it is a terminal")
+	logFetches := flag.Bool("log-fetches", false, "log every fetch with its status and duration")
+	replayPath := flag.String("replay", "", "serve responses from this WARC file, or directory of WARC files, instead of the network")
+	previousPath := flag.String("previous", "", "SQLite database of a previous crawl; unchanged pages are revalidated instead of downloaded")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: crawler [flags] <seedURL>... <maxConcurrency> <maxPages>\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "       crawler diff [-format text|json] <old.db> <new.db>\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var logOutput io.Writer = os.Stderr
+	var display *progressDisplay
+	if *progress {
+		// Log lines are printed above the progress display, which is nil
+		// when stderr is not a terminal.
+		if display = newProgressDisplay(os.Stderr); display != nil {
+			logOutput = display
+		}
+	}
+	logger, err := newLogger(logOutput, *logLevel, *logFormat)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	args := flag.Args()
+
+	minArgs := 3
+	if *seedsFile != "" || *resumeDir != "" {
+		minArgs = 2
+	}
+
+	if len(args) < minArgs {
+		logger.Error("too few arguments")
+		return 1
+	}
+
+	seeds := args[:len(args)-2]
+	if *seedsFile != "" {
+		fileSeeds, err := crawler.ReadSeedsFile(*seedsFile)
+		if err != nil {
+			logger.Error("error reading seeds", "error", err)
+			return 1
+		}
+		seeds = append(seeds, fileSeeds...)
+	}
+	if len(seeds) == 0 && *resumeDir == "" {
+		logger.Error("no seed URLs provided")
+		return 1
+	}
+
+	if *bodyLimitMode != "truncate" && *bodyLimitMode != "reject" {
+		logger.Error("unknown body limit mode", "body_limit_mode", *bodyLimitMode)
+		return 1
+	}
+
+	if *output != "report" && *output != "sitemap" && *output != "audit" && *output != "duplicates" {
+		logger.Error("unknown output mode", "output_mode", *output)
+		return 1
+	}
+
+	if *format != "text" && *format != "json" {
+		logger.Error("unknown format", "format", *format)
+		return 1
+	}
+
+	// Anything else on stdout would break up the stream of JSON lines.
+	if *streamPath == "-" && (*output == "audit" || *output == "duplicates") {
+		logger.Error("cannot stream pages to stdout with this output mode", "output_mode", *output)
+		return 1
+	}
+
+	if *similarityThreshold < 0 || *similarityThreshold > 1 {
+		logger.Error("similarity must be between 0 and 1", "similarity", *similarityThreshold)
+		return 1
+	}
+
+	maxThreadCount, err := strconv.Atoi(args[len(args)-2])
+	if err != nil {
+		logger.Error("error reading maxConcurrency", "error", err)
+		return 1
+	}
+	maxPageCount, err := strconv.Atoi(args[len(args)-1])
+	if err != nil {
+		logger.Error("error reading maxPages", "error", err)
+		return 1
+	}
+
+	opts := []crawler.Option{
+		crawler.WithLogger(logger),
+		crawler.WithMaxConcurrency(maxThreadCount),
+		crawler.WithMaxPages(maxPageCount),
+		crawler.WithBodyLimit(*maxBodySize, *bodyLimitMode == "truncate"),
+		crawler.WithDecompressionLimits(*maxDecompressed, *maxRatio),
+		crawler.WithIgnoreRobotsMeta(*ignoreRobotsMeta),
+		crawler.WithTrapLimits(crawler.TrapLimits{
+			MaxSegmentRepeats: *maxSegmentRepeats,
+			MaxPathDepth:      *maxPathDepth,
+			MaxQueryParams:    *maxQueryParams,
+			PatternBudget:     *patternBudget,
+		}),
+		crawler.WithStore(*storeBackend, *storeDir),
+		crawler.WithRetries(*retries, *retryBackoff),
+	}
+
+	switch *seenMode {
+	case "exact":
+	case "bloom":
+		opts = append(opts, crawler.WithBloomFilter(*expectedURLs, *fpRate))
+	default:
+		logger.Error("unknown seen-set mode", "seen_set_mode", *seenMode)
+		return 1
+	}
+
+	if *previousPath != "" {
+		opts = append(opts, crawler.WithPreviousCrawl(*previousPath))
+	}
+	if *replayPath != "" {
+		opts = append(opts, crawler.WithReplay(*replayPath))
+	}
+	if *warcDir != "" {
+		opts = append(opts, crawler.WithWARC(*warcDir, *warcPrefix, *warcMaxSize))
+	}
+	if *logFetches {
+		opts = append(opts, crawler.WithFetchLogging())
+	}
+	if *resumeDir != "" {
+		opts = append(opts, crawler.WithResume(*resumeDir))
+	}
+	if *stateDir != "" {
+		opts = append(opts, crawler.WithCheckpoints(*stateDir, *checkpointInterval))
+	}
+	switch *streamPath {
+	case "":
+	case "-":
+		opts = append(opts, crawler.WithResultStream(os.Stdout))
+	default:
+		// A resumed crawl appends, keeping what the interrupted run
+		// already streamed.
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if *resumeDir != "" {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		f, err := os.OpenFile(*streamPath, flags, 0o644)
+		if err != nil {
+			logger.Error("error opening stream file", "error", err)
+			return 1
+		}
+		defer f.Close()
+		opts = append(opts, crawler.WithResultStream(f))
+	}
+
+	c, err := crawler.New(seeds, opts...)
+	if err != nil {
+		logger.Error("error setting up the crawl", "error", err)
+		return 1
+	}
+	defer c.Close()
+
+	// Stop cleanly when interrupted; with checkpoints on, the crawl can
+	// then be resumed.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	for _, seed := range c.Seeds() {
+		logger.Info("starting crawl", "seed", seed)
+	}
+
+	stopProgress := func() {}
+	if display != nil {
+		stopProgress = display.show(c, 500*time.Millisecond)
+	}
+	results, err := c.Run(ctx)
+	stopProgress()
+	if errors.Is(err, context.Canceled) {
+		return 130
+	}
+	if err != nil {
+		logger.Error("crawl finished with an error", "error", err)
+	}
+
+	if *sqlitePath != "" {
+		if err := results.WriteSQLite(*sqlitePath); err != nil {
+			logger.Error("error writing SQLite database", "error", err)
+			return 1
+		}
+	}
+
+	switch *output {
+	case "sitemap":
+		entries, err := results.SitemapEntries(*sitemapPriority)
+		if err != nil {
+			logger.Error("error building sitemap", "error", err)
+			return 1
+		}
+		base := *sitemapBaseURL
+		if base == "" {
+			base = c.Seeds()[0]
+		}
+		files, err := crawler.WriteSitemap(*sitemapFile, base, entries)
+		if err != nil {
+			logger.Error("error writing sitemap", "error", err)
+			return 1
+		}
+		logger.Info("wrote sitemap", "urls", len(entries), "files", files)
+	case "audit":
+		report := results.Audit()
+		if *format == "json" {
+			if err := report.WriteJSON(os.Stdout); err != nil {
+				logger.Error("error writing audit", "error", err)
+				return 1
+			}
+		} else {
+			report.WriteText(os.Stdout)
+		}
+	case "duplicates":
+		report := results.Duplicates(*similarityThreshold)
+		if *format == "json" {
+			if err := report.WriteJSON(os.Stdout); err != nil {
+				logger.Error("error writing duplicates", "error", err)
+				return 1
+			}
+		} else {
+			report.WriteText(os.Stdout)
+		}
+	default:
+		if *streamPath != "-" {
+			results.WriteReport(os.Stdout)
+		}
+	}
+	return 0
+}
+
+// runDiff implements "crawler diff", comparing two crawls stored with
+// -sqlite, and returns the exit status.
+func runDiff(arguments []string) int {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	format := flags.String("format", "text", "format of the diff: text or json")
+	logLevel := flags.String("log-level", "info", "lowest level of the messages logged to stderr: debug, info, warn or error")
+	logFormat := flags.String("log-format", "text", "format of the messages logged to stderr: text or json")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: crawler diff [flags] <old.db> <new.db>\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(arguments)
+
+	logger, err := newLogger(os.Stderr, *logLevel, *logFormat)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	args := flags.Args()
+	if len(args) != 2 {
+		logger.Error("diff needs exactly two crawl databases")
+		return 1
+	}
+	if *format != "text" && *format != "json" {
+		logger.Error("unknown format", "format", *format)
+		return 1
+	}
+
+	diff, err := crawler.DiffCrawls(args[0], args[1])
+	if err != nil {
+		logger.Error("error loading crawl", "error", err)
+		return 1
+	}
+	if *format == "json" {
+		if err := diff.WriteJSON(os.Stdout); err != nil {
+			logger.Error("error writing diff", "error", err)
+			return 1
+		}
+		return 0
+	}
+	diff.WriteText(os.Stdout)
+	return 0
+}
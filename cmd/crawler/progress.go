@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+
+	"github.com/vladimirck/crawler"
+)
+
+// progressHosts is how many of the busiest hosts the display lists.
+const progressHosts = 5
+
+// progressDisplay redraws the crawl's progress in place at the bottom of
+// a terminal. It is also the writer for log lines, which it prints above
+// the drawing so the two do not garble each other.
+type progressDisplay struct {
+	mu    sync.Mutex
+	w     io.Writer
+	last  *crawler.Progress // nil until the first drawing
+	lines int               // lines drawn last time, to move back over
+}
+
+// newProgressDisplay returns a display on f, or nil when f is not a
+// terminal.
+func newProgressDisplay(f *os.File) *progressDisplay {
+	if !term.IsTerminal(int(f.Fd())) {
+		return nil
+	}
+	return &progressDisplay{w: f}
+}
+
+// draw replaces the previous drawing with p.
+func (d *progressDisplay) draw(p crawler.Progress) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.last = &p
+	io.WriteString(d.w, d.erase()+d.render(p))
+}
+
+// Write prints a log line above the drawing.
+func (d *progressDisplay) Write(line []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, err := io.WriteString(d.w, d.erase()); err != nil {
+		return 0, err
+	}
+	n, err := d.w.Write(line)
+	if d.last != nil {
+		io.WriteString(d.w, d.render(*d.last))
+	}
+	return n, err
+}
+
+// erase returns the escape codes that clear the last drawing, leaving
+// the cursor where it started.
+func (d *progressDisplay) erase() string {
+	if d.lines == 0 {
+		return ""
+	}
+	lines := d.lines
+	d.lines = 0
+	return fmt.Sprintf("\033[%dA\r\033[J", lines)
+}
+
+func (d *progressDisplay) render(p crawler.Progress) string {
+	lines := []string{p.String()}
+	if hosts := p.Hosts(progressHosts); len(hosts) > 0 {
+		queues := make([]string, len(hosts))
+		for i, host := range hosts {
+			queues[i] = fmt.Sprintf("%s %d", host, p.HostQueues[host])
+		}
+		lines = append(lines, "queued by host: "+strings.Join(queues, ", "))
+	}
+	d.lines = len(lines)
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// show redraws c's progress every interval. The returned function draws
+// it a last time and stops.
+func (d *progressDisplay) show(c *crawler.Crawler, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.draw(c.Progress())
+			case <-done:
+				d.draw(c.Progress())
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
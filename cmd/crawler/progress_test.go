@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/vladimirck/crawler"
+)
+
+func TestProgressDisplay(t *testing.T) {
+	tests := []struct {
+		name      string
+		progress  crawler.Progress
+		wantLines int
+		want      []string
+	}{
+		{
+			name:      "no queue",
+			progress:  crawler.Progress{Fetched: 3, MaxPages: 10},
+			wantLines: 1,
+			want:      []string{"3/10 pages", "ETA -"},
+		},
+		{
+			name: "busiest hosts",
+			progress: crawler.Progress{
+				Fetched:    4,
+				Failed:     1,
+				Queued:     7,
+				MaxPages:   10,
+				HostQueues: map[string]int{"a.example": 2, "b.example": 5},
+			},
+			wantLines: 2,
+			want:      []string{"5/10 pages, 1 failed, 7 queued", "queued by host: b.example 5, a.example 2"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			d := &progressDisplay{w: &out}
+			d.draw(tc.progress)
+			if d.lines != tc.wantLines {
+				t.Errorf("drew %d lines, want %d", d.lines, tc.wantLines)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("output %q lacks %q", out.String(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestProgressDisplayRedraw(t *testing.T) {
+	var out bytes.Buffer
+	d := &progressDisplay{w: &out}
+	d.draw(crawler.Progress{HostQueues: map[string]int{"a.example": 1}})
+	out.Reset()
+
+	d.draw(crawler.Progress{})
+	if got := out.String(); !strings.HasPrefix(got, "\033[2A\r\033[J") {
+		t.Errorf("redraw %q does not clear the 2 lines drawn before", got)
+	}
+	if d.lines != 1 {
+		t.Errorf("drew %d lines, want 1", d.lines)
+	}
+}
+
+func TestProgressDisplayLogLines(t *testing.T) {
+	var out bytes.Buffer
+	d := &progressDisplay{w: &out}
+
+	// Log lines before the first drawing go straight through.
+	d.Write([]byte("starting\n"))
+	if out.String() != "starting\n" {
+		t.Errorf("got %q before any drawing", out.String())
+	}
+	out.Reset()
+
+	d.draw(crawler.Progress{Fetched: 1, MaxPages: 2})
+	out.Reset()
+	d.Write([]byte("page crawled\n"))
+	got := out.String()
+	if !strings.HasPrefix(got, "\033[1A\r\033[Jpage crawled\n") {
+		t.Errorf("log line %q is not printed in place of the drawing", got)
+	}
+	if !strings.HasSuffix(got, "1/2 pages, 0 failed, 0 queued, 0 active, 0.0 pages/s, 0 B, ETA -\n") {
+		t.Errorf("drawing is not redrawn below the log line: %q", got)
+	}
+}
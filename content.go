@@ -0,0 +1,256 @@
+package crawler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Elements holding navigation and other furniture repeated across a
+// site. Their text is left out of the main text, so two pages differing
+// only in content are not made similar by a shared menu and footer.
+var boilerplateElements = map[string]bool{
+	"nav":    true,
+	"header": true,
+	"footer": true,
+	"aside":  true,
+	"form":   true,
+}
+
+// shingleSize is the number of consecutive words making up one SimHash
+// feature.
+const shingleSize = 3
+
+// DefaultSimilarity is the fraction of SimHash bits two pages must share
+// to be near-duplicates: 61 of 64, i.e. at most 3 bits apart.
+const DefaultSimilarity = 0.95
+
+// contentHasher fingerprints the main text of a page as it is streamed
+// through it: a SHA-256 of the normalized words for exact duplicates and
+// a 64-bit SimHash of word shingles for near-duplicates.
+type contentHasher struct {
+	sha     hash.Hash
+	weights [64]int
+	window  []string // last shingleSize-1 words
+	words   int
+}
+
+func newContentHasher() *contentHasher {
+	return &contentHasher{sha: sha256.New()}
+}
+
+// add feeds a chunk of visible text. Words are lowercased so case-only
+// differences do not count.
+func (h *contentHasher) add(text string) {
+	for _, word := range strings.Fields(text) {
+		word = strings.ToLower(word)
+		if h.words > 0 {
+			h.sha.Write([]byte{' '})
+		}
+		h.sha.Write([]byte(word))
+		h.words++
+
+		h.window = append(h.window, word)
+		if len(h.window) == shingleSize {
+			h.feature(strings.Join(h.window, " "))
+			h.window = h.window[1:]
+		}
+	}
+}
+
+func (h *contentHasher) feature(shingle string) {
+	f := fnv.New64a()
+	f.Write([]byte(shingle))
+	sum := f.Sum64()
+	for i := range h.weights {
+		if sum&(1<<i) != 0 {
+			h.weights[i]++
+		} else {
+			h.weights[i]--
+		}
+	}
+}
+
+// sums returns the hex SHA-256 and the SimHash of the text added so far.
+// A page without any text has neither.
+func (h *contentHasher) sums() (string, uint64) {
+	if h.words == 0 {
+		return "", 0
+	}
+	// Texts shorter than a shingle are one feature on their own.
+	if h.words < shingleSize {
+		h.feature(strings.Join(h.window, " "))
+	}
+	var simhash uint64
+	for i, w := range h.weights {
+		if w > 0 {
+			simhash |= 1 << i
+		}
+	}
+	return hex.EncodeToString(h.sha.Sum(nil)), simhash
+}
+
+// similarity is the fraction of SimHash bits a and b have in common.
+func similarity(a, b uint64) float64 {
+	return 1 - float64(bits.OnesCount64(a^b))/64
+}
+
+// maxDistance is the largest number of differing SimHash bits that still
+// meets the similarity threshold.
+func maxDistance(threshold float64) int {
+	// Rounded so a threshold of exactly 61/64 allows 3 bits, not 2.
+	d := int((1-threshold)*64 + 1e-9)
+	return min(max(d, 0), 64)
+}
+
+// DuplicateCluster is a group of pages with the same or nearly the same
+// main text.
+type DuplicateCluster struct {
+	Hash string   `json:"hash"` // content hash of the exact duplicates, or SimHash of the first page
+	URLs []string `json:"urls"`
+}
+
+// DuplicateReport lists the clusters of exact and near-duplicate pages
+// found in a crawl.
+type DuplicateReport struct {
+	PagesCompared  int                `json:"pages_compared"`
+	Similarity     float64            `json:"similarity"`
+	Exact          []DuplicateCluster `json:"exact"`
+	NearDuplicates []DuplicateCluster `json:"near_duplicates"`
+}
+
+// duplicates groups successfully fetched pages by their content hashes.
+// Exact clusters share a SHA-256; near-duplicate clusters join pages of
+// different text whose SimHashes are at least threshold similar, directly
+// or through other pages of the cluster.
+func (cfg *config) duplicates(threshold float64) DuplicateReport {
+	cfg.resultMu.Lock()
+	byHash := map[string][]string{}
+	simhashes := map[string]uint64{}
+	err := cfg.results.each(func(_ string, page *Page) bool {
+		if page.Err != "" || page.StatusCode != 200 || page.ContentHash == "" {
+			return true
+		}
+		u := reportURL(page)
+		byHash[page.ContentHash] = append(byHash[page.ContentHash], u)
+		simhashes[page.ContentHash] = page.SimHash
+		return true
+	})
+	cfg.resultMu.Unlock()
+	if err != nil {
+		cfg.logger.Error("error reading pages", "error", err)
+	}
+
+	report := DuplicateReport{
+		Similarity:     threshold,
+		Exact:          []DuplicateCluster{},
+		NearDuplicates: []DuplicateCluster{},
+	}
+
+	hashes := make([]string, 0, len(byHash))
+	for h, urls := range byHash {
+		sort.Strings(urls)
+		report.PagesCompared += len(urls)
+		hashes = append(hashes, h)
+		if len(urls) > 1 {
+			report.Exact = append(report.Exact, DuplicateCluster{Hash: h, URLs: urls})
+		}
+	}
+	sort.Strings(hashes)
+
+	// Near-duplicates are found among distinct texts only. By the
+	// pigeonhole principle two SimHashes at most k bits apart agree on
+	// at least one of k+1 blocks, so only pages sharing a block are
+	// compared instead of every pair.
+	k := maxDistance(threshold)
+	parent := make([]int, len(hashes))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	blocks := k + 1
+	buckets := map[[2]uint64][]int{}
+	for i, h := range hashes {
+		sum := simhashes[h]
+		for b := range blocks {
+			lo, hi := b*64/blocks, (b+1)*64/blocks
+			mask := uint64(1)<<(hi-lo) - 1
+			key := [2]uint64{uint64(b), (sum >> lo) & mask}
+			for _, j := range buckets[key] {
+				if bits.OnesCount64(sum^simhashes[hashes[j]]) <= k {
+					parent[find(i)] = find(j)
+				}
+			}
+			buckets[key] = append(buckets[key], i)
+		}
+	}
+
+	clusters := map[int][]int{}
+	for i := range hashes {
+		root := find(i)
+		clusters[root] = append(clusters[root], i)
+	}
+	for _, members := range clusters {
+		if len(members) < 2 {
+			continue
+		}
+		cluster := DuplicateCluster{Hash: fmt.Sprintf("%016x", simhashes[hashes[members[0]]]), URLs: []string{}}
+		for _, i := range members {
+			cluster.URLs = append(cluster.URLs, byHash[hashes[i]]...)
+		}
+		sort.Strings(cluster.URLs)
+		report.NearDuplicates = append(report.NearDuplicates, cluster)
+	}
+
+	sort.Slice(report.Exact, func(i, j int) bool { return report.Exact[i].URLs[0] < report.Exact[j].URLs[0] })
+	sort.Slice(report.NearDuplicates, func(i, j int) bool {
+		return report.NearDuplicates[i].URLs[0] < report.NearDuplicates[j].URLs[0]
+	})
+	return report
+}
+
+// WriteText writes the clusters as a plain-text report.
+func (report DuplicateReport) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "\n\n\n=============================\n")
+	fmt.Fprintf(w, "DUPLICATES among %d pages\n", report.PagesCompared)
+	fmt.Fprintf(w, "=============================\n")
+
+	fmt.Fprintf(w, "\nExact duplicates (%d clusters)\n", len(report.Exact))
+	for _, cluster := range report.Exact {
+		fmt.Fprintf(w, "%s\n", cluster.Hash[:16])
+		for _, u := range cluster.URLs {
+			fmt.Fprintf(w, "    %s\n", u)
+		}
+	}
+
+	fmt.Fprintf(w, "\nNear duplicates, %s%% similar or more (%d clusters)\n",
+		strconv.FormatFloat(report.Similarity*100, 'f', -1, 64), len(report.NearDuplicates))
+	for _, cluster := range report.NearDuplicates {
+		fmt.Fprintf(w, "%s\n", cluster.Hash)
+		for _, u := range cluster.URLs {
+			fmt.Fprintf(w, "    %s\n", u)
+		}
+	}
+}
+
+// WriteJSON writes the clusters as indented JSON.
+func (report DuplicateReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
@@ -0,0 +1,151 @@
+package crawler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func contentSums(t *testing.T, body string) (string, uint64) {
+	t.Helper()
+	extract, err := extractPage(strings.NewReader(body), "https://example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return extract.content, extract.simhash
+}
+
+// article returns a page of n sentences, with the words of sentence
+// changed replaced.
+func article(n, changed int, nav string) string {
+	var b strings.Builder
+	b.WriteString("<html><body><nav>" + nav + "</nav><main>")
+	for i := range n {
+		if i == changed {
+			fmt.Fprintf(&b, "<p>Something entirely different was written here instead.</p>")
+			continue
+		}
+		fmt.Fprintf(&b, "<p>Sentence number %d talks about crawling the web politely.</p>", i)
+	}
+	b.WriteString("</main><footer>Copyright " + nav + "</footer></body></html>")
+	return b.String()
+}
+
+func TestContentHash(t *testing.T) {
+	base, baseSim := contentSums(t, article(40, -1, "Home About"))
+
+	tests := []struct {
+		name      string
+		body      string
+		sameHash  bool
+		maxBitsOf int // maximum SimHash distance from base
+	}{
+		{"Whitespace and case", strings.ReplaceAll(strings.ToUpper(article(40, -1, "home about")), "<P>", "<P>\n\n"), true, 0},
+		{"Different navigation", article(40, -1, "Products Contact Blog"), true, 0},
+		{"Scripts are ignored", strings.Replace(article(40, -1, "Home About"), "<main>", "<main><script>var x = 1;</script>", 1), true, 0},
+		{"One sentence changed", article(40, 7, "Home About"), false, 10},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			hash, sim := contentSums(t, tc.body)
+			if (hash == base) != tc.sameHash {
+				t.Errorf("content hash equal to base = %v; want %v", hash == base, tc.sameHash)
+			}
+			if d := bits.OnesCount64(sim ^ baseSim); d > tc.maxBitsOf {
+				t.Errorf("SimHash is %d bits from base; want at most %d", d, tc.maxBitsOf)
+			}
+		})
+	}
+
+	t.Run("Unrelated page", func(t *testing.T) {
+		_, sim := contentSums(t, "<html><body><p>"+strings.Repeat("a completely unrelated recipe for soup with leeks ", 20)+"</p></body></html>")
+		if d := bits.OnesCount64(sim ^ baseSim); d <= maxDistance(DefaultSimilarity) {
+			t.Errorf("unrelated page is only %d bits from base", d)
+		}
+	})
+
+	t.Run("No text", func(t *testing.T) {
+		hash, sim := contentSums(t, "<html><head><title>Empty</title></head><body><nav>Menu</nav></body></html>")
+		if hash != "" || sim != 0 {
+			t.Errorf("sums of a page without main text = %q, %x; want none", hash, sim)
+		}
+	})
+}
+
+func TestMaxDistance(t *testing.T) {
+	tests := []struct {
+		threshold float64
+		want      int
+	}{
+		{1, 0},
+		{DefaultSimilarity, 3},
+		{61.0 / 64, 3},
+		{0.9, 6},
+		{0, 64},
+	}
+	for _, tc := range tests {
+		if got := maxDistance(tc.threshold); got != tc.want {
+			t.Errorf("maxDistance(%v) = %d; want %d", tc.threshold, got, tc.want)
+		}
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	c := newConfig([]string{"https://example.com"}, 1, 100)
+	add := func(path, hash string, simhash uint64) {
+		u := "https://example.com" + path
+		// Keyed by the full URL: normalizeURL would merge the query string away.
+		c.results.put(u, &Page{URL: u, FinalURL: u, StatusCode: 200, ContentHash: hash, SimHash: simhash})
+	}
+
+	const near = 0xF0F0_F0F0_F0F0_F0F0
+	add("/a", "aaaa", near)
+	add("/a?utm=1", "aaaa", near)
+	add("/b", "bbbb", near^0b101)      // 2 bits from /a
+	add("/c", "cccc", near^0b101<<40)  // 2 bits from /a, 4 from /b
+	add("/far", "dddd", ^uint64(near)) // 64 bits away
+	add("/empty", "", 0)               // no main text
+	c.results.put("example.com/broken", &Page{URL: "https://example.com/broken", StatusCode: 404, ContentHash: "aaaa"})
+
+	report := c.duplicates(DefaultSimilarity)
+
+	if report.PagesCompared != 5 {
+		t.Errorf("PagesCompared = %d; want 5", report.PagesCompared)
+	}
+	wantExact := []DuplicateCluster{{Hash: "aaaa", URLs: []string{"https://example.com/a", "https://example.com/a?utm=1"}}}
+	if !reflect.DeepEqual(report.Exact, wantExact) {
+		t.Errorf("Exact = %+v; want %+v", report.Exact, wantExact)
+	}
+	if len(report.NearDuplicates) != 1 {
+		t.Fatalf("NearDuplicates = %+v; want one cluster", report.NearDuplicates)
+	}
+	wantNear := []string{"https://example.com/a", "https://example.com/a?utm=1", "https://example.com/b", "https://example.com/c"}
+	if got := report.NearDuplicates[0].URLs; !reflect.DeepEqual(got, wantNear) {
+		t.Errorf("near-duplicate cluster = %v; want %v", got, wantNear)
+	}
+
+	t.Run("Strict threshold", func(t *testing.T) {
+		if got := c.duplicates(1).NearDuplicates; len(got) != 0 {
+			t.Errorf("NearDuplicates at similarity 1 = %+v; want none", got)
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := report.WriteJSON(&buf); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var decoded DuplicateReport
+		if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+			t.Fatalf("invalid JSON: %v", err)
+		}
+		if !reflect.DeepEqual(decoded, report) {
+			t.Errorf("JSON round trip = %+v; want %+v", decoded, report)
+		}
+	})
+}
@@ -0,0 +1,154 @@
+package crawler
+
+import (
+	"context"
+	"time"
+)
+
+// crawlItem is a page waiting in the frontier to be fetched. Its URL has
+// already been counted in cfg.pages, so each page is queued only once.
+type crawlItem struct {
+	URL   string `json:"url"`
+	Depth int    `json:"depth"`
+	Seed  string `json:"seed"`
+}
+
+// enqueue admits a discovered URL into the crawl. URLs outside every
+// seed's scope, past the page budget, suspected of being spider traps or
+// already seen are not queued; seen ones still count as one more internal
+// link to that page.
+func (cfg *config) enqueue(rawURL string, depth int) {
+	// Hold off checkpoints between marking the page seen and queueing it,
+	// so a snapshot never holds a seen page that is queued nowhere.
+	cfg.enqueueMu.RLock()
+	defer cfg.enqueueMu.RUnlock()
+
+	if cfg.checkMaxPages() {
+		return
+	}
+
+	seed, ok := cfg.seedFor(rawURL)
+	if !ok {
+		return
+	}
+
+	// Only pages not seen before are charged to the trap limits; a page
+	// seen already just gets one more link.
+	normURL := normalizeURL(rawURL)
+	seen, err := cfg.pages.has(normURL)
+	if err != nil {
+		cfg.logger.Error("error checking seen pages", "url", rawURL, "error", err)
+		return
+	}
+	if seen {
+		cfg.addPageVisit(normURL)
+		return
+	}
+	if !cfg.traps.admit(rawURL) {
+		return
+	}
+	if !cfg.addPageVisit(normURL) {
+		// Another worker got to the page first.
+		cfg.traps.release(rawURL)
+		return
+	}
+
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	if err := cfg.queue.push(crawlItem{URL: rawURL, Depth: depth, Seed: seed}); err != nil {
+		cfg.logger.Error("error queueing page", "url", rawURL, "depth", depth, "error", err)
+		return
+	}
+	cfg.counts.queueHost(rawURL)
+	cfg.cond.Signal()
+}
+
+// crawl fetches every seed and everything reachable from them, with
+// maxConcurrency workers, and returns once the frontier is exhausted or
+// ctx is cancelled. A crawl restored from a checkpoint picks up its saved
+// frontier instead of starting again from the seeds it already knew.
+func (cfg *config) crawl(ctx context.Context) {
+	cfg.resultMu.Lock()
+	cfg.counts.started = time.Now()
+	cfg.counts.doneBefore = cfg.counts.fetched + cfg.counts.failed
+	cfg.resultMu.Unlock()
+
+	for _, seed := range cfg.seeds {
+		// A resumed crawl counted its seeds the first time round; errors
+		// are left for enqueue to report.
+		if cfg.resumed {
+			if seen, err := cfg.pages.has(normalizeURL(seed)); seen && err == nil {
+				continue
+			}
+		}
+		cfg.enqueue(seed, 0)
+	}
+
+	// Wake idle workers on cancellation so they can stop.
+	stop := context.AfterFunc(ctx, func() {
+		cfg.mu.Lock()
+		defer cfg.mu.Unlock()
+		cfg.cond.Broadcast()
+	})
+	defer stop()
+
+	for id := range max(cfg.maxConcurrency, 1) {
+		cfg.wg.Add(1)
+		go cfg.worker(ctx, id)
+	}
+	cfg.wg.Wait()
+}
+
+func (cfg *config) worker(ctx context.Context, id int) {
+	defer cfg.wg.Done()
+	logger := cfg.logger.With("worker", id)
+	for {
+		item, ok := cfg.next(ctx)
+		if !ok {
+			return
+		}
+		cfg.crawlPage(ctx, item, logger)
+		cfg.finish(item, ctx.Err() != nil)
+	}
+}
+
+// next blocks until there is a page to fetch. It returns false once the
+// frontier is empty and no worker is left that could add to it, or ctx
+// is cancelled.
+func (cfg *config) next(ctx context.Context) (crawlItem, bool) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	for cfg.queue.len() == 0 || ctx.Err() != nil {
+		if ctx.Err() != nil {
+			return crawlItem{}, false
+		}
+		if cfg.active == 0 {
+			cfg.cond.Broadcast()
+			return crawlItem{}, false
+		}
+		cfg.cond.Wait()
+	}
+
+	item, _, err := cfg.queue.pop()
+	if err != nil {
+		cfg.logger.Error("error reading the frontier", "error", err)
+		return crawlItem{}, false
+	}
+	cfg.active++
+	cfg.counts.dequeueHost(item.URL)
+	cfg.inflight[normalizeURL(item.URL)] = item
+	return item, true
+}
+
+// finish marks a page as done. A page whose crawl was cancelled stays in
+// flight, so the checkpoint saved on the way out queues it again.
+func (cfg *config) finish(item crawlItem, cancelled bool) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cfg.active--
+	if !cancelled {
+		delete(cfg.inflight, normalizeURL(item.URL))
+	}
+	cfg.cond.Broadcast()
+}
@@ -0,0 +1,569 @@
+// Package crawler crawls websites from a set of seed URLs, staying on
+// each seed's host, and reports on what it found: internal link counts,
+// SEO issues, duplicate content, sitemaps, SQLite exports and WARC
+// archives.
+//
+// A crawl is set up with New and functional options and run with Run:
+//
+//	c, err := crawler.New([]string{"https://example.com"},
+//		crawler.WithMaxConcurrency(8),
+//		crawler.WithMaxPages(500),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close()
+//
+//	results, err := c.Run(ctx)
+//	if err != nil {
+//		return err
+//	}
+//	for _, page := range results.Pages() {
+//		fmt.Println(page.URL, page.StatusCode)
+//	}
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults used when the matching option is not given.
+const (
+	DefaultMaxConcurrency     = 5
+	DefaultMaxPages           = 1000
+	DefaultCheckpointInterval = time.Minute
+)
+
+type config struct {
+	pages            seenSet
+	results          resultStore
+	seeds            []string
+	limits           fetchLimits
+	traps            *trapDetector
+	fetcher          Fetcher
+	metrics          *FetchMetrics // nil when fetches are not counted
+	hooks            hookSet
+	logger           *slog.Logger
+	ignoreRobotsMeta bool
+	mu               *sync.Mutex // guards the frontier: queue, inflight, active
+	cond             *sync.Cond  // signalled on cfg.mu when the frontier changes
+	resultMu         *sync.Mutex // guards what is kept about finished pages
+	enqueueMu        *sync.RWMutex
+	queue            frontier
+	inflight         map[string]crawlItem // pages being fetched right now
+	active           int                  // workers busy with a page
+	counts           progressCounts
+	resumed          bool             // state was restored from a checkpoint
+	saved            checkpointLog    // results already in the last checkpoint
+	previous         map[string]*Page // pages of the previous crawl, for conditional requests
+	wg               *sync.WaitGroup
+	maxConcurrency   int
+	maxPages         int
+}
+
+func newConfig(seeds []string, maxConcurrency, maxPages int) *config {
+	mu := &sync.Mutex{}
+	return &config{
+		pages:          newShardedSeenSet(),
+		results:        newMemResults(),
+		seeds:          seeds,
+		limits:         defaultFetchLimits,
+		traps:          newTrapDetector(DefaultTrapLimits),
+		fetcher:        &HTTPFetcher{},
+		logger:         slog.New(slog.DiscardHandler),
+		mu:             mu,
+		cond:           sync.NewCond(mu),
+		resultMu:       &sync.Mutex{},
+		enqueueMu:      &sync.RWMutex{},
+		queue:          &memFrontier{},
+		inflight:       make(map[string]crawlItem),
+		wg:             &sync.WaitGroup{},
+		maxPages:       maxPages,
+		maxConcurrency: maxConcurrency,
+	}
+}
+
+// options collects what the Option functions set before New builds the
+// crawl from them.
+type options struct {
+	maxConcurrency     int
+	maxPages           int
+	limits             fetchLimits
+	ignoreRobotsMeta   bool
+	traps              TrapLimits
+	store              string
+	storeDir           string
+	bloom              bool
+	expectedURLs       int
+	fpRate             float64
+	previousPath       string
+	resumeDir          string
+	stateDir           string
+	checkpointInterval time.Duration
+	replayPath         string
+	warcDir            string
+	warcPrefix         string
+	warcMaxSize        int64
+	fetcher            Fetcher
+	middlewares        []FetcherMiddleware
+	retries            int
+	retryBackoff       time.Duration
+	logFetches         bool
+	hooks              []Hooks
+	events             chan<- Event
+	stream             io.Writer
+	logger             *slog.Logger
+}
+
+// Option configures a Crawler.
+type Option func(*options)
+
+// WithMaxConcurrency sets how many pages are fetched at once.
+func WithMaxConcurrency(n int) Option {
+	return func(o *options) { o.maxConcurrency = n }
+}
+
+// WithMaxPages sets how many distinct pages the crawl may visit.
+func WithMaxPages(n int) Option {
+	return func(o *options) { o.maxPages = n }
+}
+
+// WithBodyLimit caps the bytes read per response, 0 for no limit. Longer
+// bodies are cut at the limit when truncate is set and rejected
+// otherwise.
+func WithBodyLimit(maxSize int64, truncate bool) Option {
+	return func(o *options) {
+		o.limits.maxBodySize = maxSize
+		o.limits.truncate = truncate
+	}
+}
+
+// WithDecompressionLimits guards against decompression bombs: bodies may
+// expand to at most maxSize bytes and maxRatio times their compressed
+// size. Zero turns a limit off.
+func WithDecompressionLimits(maxSize int64, maxRatio float64) Option {
+	return func(o *options) {
+		o.limits.maxDecompressedSize = maxSize
+		o.limits.maxCompressionRatio = maxRatio
+	}
+}
+
+// WithIgnoreRobotsMeta follows links on nofollow pages and lists noindex
+// pages in sitemaps.
+func WithIgnoreRobotsMeta(ignore bool) Option {
+	return func(o *options) { o.ignoreRobotsMeta = ignore }
+}
+
+// WithTrapLimits sets the spider-trap heuristics applied to discovered
+// URLs.
+func WithTrapLimits(limits TrapLimits) Option {
+	return func(o *options) { o.traps = limits }
+}
+
+// WithStore keeps the frontier, seen-set and finished pages in backend,
+// StoreMemory or StoreDisk. The disk store lives in dir, which must not
+// hold the store of an earlier crawl, or in a temporary directory removed
+// by Close when dir is empty.
+func WithStore(backend, dir string) Option {
+	return func(o *options) {
+		o.store = backend
+		o.storeDir = dir
+	}
+}
+
+// WithBloomFilter remembers seen URLs in a fixed-size bloom filter sized
+// for expectedURLs (the page budget when 0) at the given false-positive
+// rate, instead of exactly.
+func WithBloomFilter(expectedURLs int, fpRate float64) Option {
+	return func(o *options) {
+		o.bloom = true
+		o.expectedURLs = expectedURLs
+		o.fpRate = fpRate
+	}
+}
+
+// WithPreviousCrawl revalidates pages stored by an earlier crawl in the
+// SQLite database at path, instead of downloading unchanged ones again.
+func WithPreviousCrawl(path string) Option {
+	return func(o *options) { o.previousPath = path }
+}
+
+// WithCheckpoints saves the crawl state to dir every interval, when the
+// crawl ends and when its context is cancelled.
+func WithCheckpoints(dir string, interval time.Duration) Option {
+	return func(o *options) {
+		o.stateDir = dir
+		o.checkpointInterval = interval
+	}
+}
+
+// WithResume continues the crawl checkpointed in dir. Unless
+// WithCheckpoints says otherwise, new checkpoints go to the same place.
+func WithResume(dir string) Option {
+	return func(o *options) { o.resumeDir = dir }
+}
+
+// WithReplay serves responses from a WARC file, or a directory of them,
+// instead of the network.
+func WithReplay(path string) Option {
+	return func(o *options) { o.replayPath = path }
+}
+
+// WithWARC archives every request and response in gzipped WARC files in
+// dir, starting a new file once one reaches maxSize bytes (0 for no
+// limit).
+func WithWARC(dir, prefix string, maxSize int64) Option {
+	return func(o *options) {
+		o.warcDir = dir
+		o.warcPrefix = prefix
+		o.warcMaxSize = maxSize
+	}
+}
+
+// WithFetcher fetches pages with f instead of an HTTPFetcher. It cannot
+// be combined with WithReplay or WithWARC, which plug into the
+// HTTPFetcher's transport.
+func WithFetcher(f Fetcher) Option {
+	return func(o *options) { o.fetcher = f }
+}
+
+// WithFetcherMiddleware wraps the fetcher in middlewares, inside the
+// retry, logging and metrics ones the Crawler adds itself.
+func WithFetcherMiddleware(middlewares ...FetcherMiddleware) Option {
+	return func(o *options) { o.middlewares = append(o.middlewares, middlewares...) }
+}
+
+// WithRetries retries a fetch that hits a network error or timeout, or
+// gets a 429 or 5xx answer, up to n times, waiting backoff before the
+// first retry and twice as long before each one after.
+func WithRetries(n int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.retries = n
+		o.retryBackoff = backoff
+	}
+}
+
+// WithFetchLogging logs every fetch, retries included, through the
+// crawler's logger.
+func WithFetchLogging() Option {
+	return func(o *options) { o.logFetches = true }
+}
+
+// WithHooks calls h as the crawl makes progress. It may be given more
+// than once; hooks run in the order they were added.
+func WithHooks(h Hooks) Option {
+	return func(o *options) { o.hooks = append(o.hooks, h) }
+}
+
+// WithEvents sends an Event to ch for every hook call, after any hooks
+// added with WithHooks. The crawl waits while ch is full, so it must be
+// drained; ch is closed when Run returns.
+func WithEvents(ch chan<- Event) Option {
+	return func(o *options) { o.events = ch }
+}
+
+// WithResultStream writes every page to w as a line of JSON (NDJSON) as
+// soon as it finishes, so results can be processed while the crawl runs.
+// Writers with a Flush method are flushed after every line. A write
+// error stops the stream and is returned by Run.
+func WithResultStream(w io.Writer) Option {
+	return func(o *options) { o.stream = w }
+}
+
+// WithLogger sends the crawl's diagnostics to logger: a line per page
+// with its url, depth, status, duration and worker id, and any errors
+// reading or writing the crawl's state. Without it they are dropped.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) { o.logger = logger }
+}
+
+// Crawler crawls the sites of its seed URLs. Build one with New, run it
+// with Run, and call Close once its results are no longer needed.
+type Crawler struct {
+	cfg                *config
+	stateDir           string
+	checkpointInterval time.Duration
+	closers            []func() error // run by Close, last first
+	events             *eventSink     // nil without WithEvents
+	stream             *resultStream  // nil without WithResultStream
+}
+
+// New sets up a crawl of seeds. Seeds may be empty when the crawl is
+// resumed from a checkpoint.
+func New(seeds []string, opts ...Option) (*Crawler, error) {
+	o := options{
+		maxConcurrency:     DefaultMaxConcurrency,
+		maxPages:           DefaultMaxPages,
+		limits:             defaultFetchLimits,
+		traps:              DefaultTrapLimits,
+		store:              StoreMemory,
+		fpRate:             0.001,
+		checkpointInterval: DefaultCheckpointInterval,
+		warcPrefix:         "crawl",
+		warcMaxSize:        DefaultWARCMaxSize,
+		retryBackoff:       time.Second,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if len(seeds) == 0 && o.resumeDir == "" {
+		return nil, errors.New("no seed URLs provided")
+	}
+	if err := checkSeedHosts(seeds); err != nil {
+		return nil, err
+	}
+	if o.fetcher != nil && (o.replayPath != "" || o.warcDir != "") {
+		return nil, errors.New("replay and WARC archiving need the HTTP fetcher")
+	}
+
+	c := &Crawler{
+		cfg:                newConfig(slices.Clone(seeds), o.maxConcurrency, o.maxPages),
+		stateDir:           o.stateDir,
+		checkpointInterval: o.checkpointInterval,
+	}
+	if err := c.setup(o); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Crawler) setup(o options) error {
+	cfg := c.cfg
+	if o.logger != nil {
+		cfg.logger = o.logger
+	}
+	cfg.limits = o.limits
+	cfg.ignoreRobotsMeta = o.ignoreRobotsMeta
+	cfg.traps = newTrapDetector(o.traps)
+
+	if o.previousPath != "" {
+		previous, err := loadPreviousCrawl(o.previousPath)
+		if err != nil {
+			return fmt.Errorf("error loading previous crawl: %w", err)
+		}
+		cfg.previous = previous
+	}
+
+	if o.store == StoreDisk && o.storeDir == "" {
+		dir, err := os.MkdirTemp("", "crawler-store-")
+		if err != nil {
+			return fmt.Errorf("error creating store directory: %w", err)
+		}
+		c.closers = append(c.closers, func() error { return os.RemoveAll(dir) })
+		o.storeDir = dir
+	}
+	s, err := openStores(o.store, o.storeDir)
+	if err != nil {
+		return fmt.Errorf("error opening store: %w", err)
+	}
+	cfg.queue, cfg.pages, cfg.results = s.queue, s.pages, s.results
+	c.closers = append(c.closers, s.queue.close, s.pages.close, s.results.close)
+
+	if o.bloom {
+		expected := o.expectedURLs
+		if expected == 0 {
+			expected = o.maxPages
+		}
+		bloom, err := newBloomSeenSet(expected, o.fpRate)
+		if err != nil {
+			return fmt.Errorf("error creating bloom filter: %w", err)
+		}
+		// The store's own seen-set is still closed by Close.
+		cfg.pages = bloom
+	}
+
+	// Restore before anything describing the crawl, such as the warcinfo
+	// records, looks at its seeds.
+	if o.resumeDir != "" {
+		if err := cfg.restore(o.resumeDir); err != nil {
+			return fmt.Errorf("error restoring checkpoint: %w", err)
+		}
+		cfg.logger.Info("resuming crawl", "dir", o.resumeDir, "pages_done", cfg.results.len(), "queued", cfg.queue.len())
+		if c.stateDir == "" {
+			c.stateDir = o.resumeDir
+		}
+	}
+
+	var transport http.RoundTripper
+	if o.replayPath != "" {
+		replay, err := newReplayTransport(o.replayPath)
+		if err != nil {
+			return fmt.Errorf("error reading WARC archive: %w", err)
+		}
+		transport = replay
+	}
+	if o.warcDir != "" {
+		warc, err := newWARCWriter(o.warcDir, o.warcPrefix, o.warcMaxSize, cfg.warcInfo())
+		if err != nil {
+			return fmt.Errorf("error creating WARC writer: %w", err)
+		}
+		// Every record is a complete gzip member, so files stay readable
+		// even when the process dies before Close.
+		c.closers = append(c.closers, warc.close)
+		transport = &warcTransport{next: transport, w: warc, maxBody: cfg.limits.maxBodySize, logger: cfg.logger}
+	}
+
+	cfg.hooks = o.hooks
+	if o.stream != nil {
+		c.stream = newResultStream(o.stream)
+		cfg.hooks = append(cfg.hooks, c.stream.hooks())
+	}
+	if o.events != nil {
+		c.events = &eventSink{ch: o.events}
+		cfg.hooks = append(cfg.hooks, c.events.hooks())
+	}
+
+	fetcher := o.fetcher
+	if fetcher == nil {
+		fetcher = &HTTPFetcher{Transport: transport}
+	}
+	// Hooks are outermost so they see each page once, then retries so
+	// every attempt is logged and counted.
+	cfg.metrics = &FetchMetrics{}
+	middlewares := []FetcherMiddleware{}
+	if len(cfg.hooks) > 0 {
+		middlewares = append(middlewares, cfg.hooks.middleware)
+	}
+	if o.retries > 0 {
+		middlewares = append(middlewares, WithRetry(o.retries+1, o.retryBackoff))
+	}
+	if o.logFetches {
+		middlewares = append(middlewares, WithLogging(cfg.logger))
+	}
+	middlewares = append(middlewares, WithMetrics(cfg.metrics))
+	cfg.fetcher = ChainFetcher(fetcher, append(middlewares, o.middlewares...)...)
+	return nil
+}
+
+// Seeds returns the seed URLs of the crawl, including those restored
+// from a checkpoint.
+func (c *Crawler) Seeds() []string {
+	return slices.Clone(c.cfg.seeds)
+}
+
+// Run crawls until the frontier is exhausted or ctx is cancelled. A
+// cancelled crawl stops fetching, saves a checkpoint when checkpoints are
+// on, and returns what it found so far along with ctx's error.
+func (c *Crawler) Run(ctx context.Context) (*Results, error) {
+	results := &Results{cfg: c.cfg}
+	if c.events != nil {
+		c.events.done = ctx.Done()
+		defer c.events.close()
+	}
+	defer c.cfg.hooks.finish(results)
+
+	if c.stateDir != "" {
+		stop := make(chan struct{})
+		defer close(stop)
+		go c.cfg.checkpointEvery(c.stateDir, c.checkpointInterval, stop)
+	}
+
+	c.cfg.crawl(ctx)
+
+	if c.stateDir != "" {
+		if err := c.cfg.saveCheckpoint(c.stateDir); err != nil {
+			return results, fmt.Errorf("error saving checkpoint: %w", err)
+		}
+	}
+	if c.stream != nil && c.stream.err != nil {
+		return results, errors.Join(c.stream.err, ctx.Err())
+	}
+	return results, ctx.Err()
+}
+
+// Progress reports how far the crawl has got. It may be called while
+// Run is running.
+func (c *Crawler) Progress() Progress {
+	return c.cfg.progress()
+}
+
+// Close releases the crawl's stores and finishes its WARC files.
+func (c *Crawler) Close() error {
+	var errs []error
+	for i := len(c.closers) - 1; i >= 0; i-- {
+		errs = append(errs, c.closers[i]())
+	}
+	c.closers = nil
+	return errors.Join(errs...)
+}
+
+// Results gives access to what a crawl found. It reads the crawl's
+// stores, so it is only valid until the Crawler is closed.
+type Results struct {
+	cfg *config
+}
+
+// Pages returns every page the crawl requested, sorted by URL.
+func (r *Results) Pages() []*Page {
+	pages := make([]*Page, 0, r.cfg.results.len())
+	err := r.cfg.results.each(func(_ string, page *Page) bool {
+		pages = append(pages, page)
+		return true
+	})
+	if err != nil {
+		r.cfg.logger.Error("error reading pages", "error", err)
+	}
+	// The store orders pages by normalized URL, which is lowercased.
+	slices.SortFunc(pages, func(a, b *Page) int {
+		return strings.Compare(a.URL, b.URL)
+	})
+	return pages
+}
+
+// InternalLinks returns the number of internal links found to every
+// page, keyed by normalized URL (host and path).
+func (r *Results) InternalLinks() map[string]int {
+	return r.cfg.pageCounts()
+}
+
+// WriteReport writes the plain-text link report.
+func (r *Results) WriteReport(w io.Writer) {
+	r.cfg.writeReport(w)
+}
+
+// Audit checks the pages for common SEO problems.
+func (r *Results) Audit() AuditReport {
+	return r.cfg.audit()
+}
+
+// Duplicates groups pages with the same main text, and pages sharing at
+// least threshold of their SimHash bits.
+func (r *Results) Duplicates(threshold float64) DuplicateReport {
+	return r.cfg.duplicates(threshold)
+}
+
+// SitemapEntries lists the pages that belong in a sitemap, with
+// priorities computed by mode: PriorityNone, PriorityDepth or
+// PriorityPageRank.
+func (r *Results) SitemapEntries(mode string) ([]SitemapEntry, error) {
+	return r.cfg.sitemapEntries(mode)
+}
+
+// SuspectedTraps lists the links kept out of the crawl as likely spider
+// traps.
+func (r *Results) SuspectedTraps() []SuspectedTrap {
+	return r.cfg.traps.traps()
+}
+
+// Metrics returns the fetch counters of the crawl.
+func (r *Results) Metrics() *FetchMetrics {
+	return r.cfg.metrics
+}
+
+// WriteSQLite stores the results in a new SQLite database at path,
+// replacing any file there.
+func (r *Results) WriteSQLite(path string) error {
+	return r.cfg.writeSQLite(path)
+}
@@ -0,0 +1,127 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		seeds   []string
+		opts    []Option
+		wantErr bool
+	}{
+		{name: "seed", seeds: []string{"https://example.com"}},
+		{name: "no seeds", wantErr: true},
+		{
+			name:    "fetcher with replay",
+			seeds:   []string{"https://example.com"},
+			opts:    []Option{WithFetcher(&HTTPFetcher{}), WithReplay(t.TempDir())},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := New(tc.seeds, tc.opts...)
+			if tc.wantErr {
+				if err == nil {
+					c.Close()
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer c.Close()
+			if len(c.Seeds()) != len(tc.seeds) {
+				t.Errorf("Seeds() = %v, want %v", c.Seeds(), tc.seeds)
+			}
+		})
+	}
+}
+
+func TestCrawlerRun(t *testing.T) {
+	server := createMockServer(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			// /B is stored under example.com/b but sorts before /a.
+			fmt.Fprint(w, createHTML("Home", []string{"/a", "/B"}))
+		case "/a":
+			fmt.Fprint(w, createHTML("A", []string{"/"}))
+		case "/B":
+			fmt.Fprint(w, createHTML("B", nil))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer server.Close()
+
+	c, err := New([]string{server.URL}, WithMaxConcurrency(2), WithMaxPages(10))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer c.Close()
+
+	results, err := c.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	pages := results.Pages()
+	if len(pages) != 3 {
+		t.Fatalf("got %d pages, want 3", len(pages))
+	}
+	for i := 1; i < len(pages); i++ {
+		if pages[i-1].URL > pages[i].URL {
+			t.Errorf("pages not sorted: %s before %s", pages[i-1].URL, pages[i].URL)
+		}
+	}
+	if n := results.Metrics().Requests.Load(); n != 3 {
+		t.Errorf("got %d requests, want 3", n)
+	}
+}
+
+func TestCrawlerRunCancelled(t *testing.T) {
+	server := createMockServer(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, createHTML("Home", []string{"/a"}))
+	})
+	defer server.Close()
+
+	dir := t.TempDir()
+	c, err := New([]string{server.URL}, WithCheckpoints(dir, DefaultCheckpointInterval))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results, err := c.Run(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run error = %v, want context.Canceled", err)
+	}
+	if len(results.Pages()) != 0 {
+		t.Errorf("got %d pages from a cancelled crawl, want 0", len(results.Pages()))
+	}
+
+	resumed, err := New(nil, WithResume(dir))
+	if err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	defer resumed.Close()
+	results, err = resumed.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run after resume: %v", err)
+	}
+	if len(results.Pages()) != 2 {
+		t.Errorf("got %d pages after resume, want 2", len(results.Pages()))
+	}
+}
@@ -0,0 +1,253 @@
+package crawler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// storedPage is the part of a stored page that crawl diffs compare.
+type storedPage struct {
+	StatusCode  int
+	Title       string
+	Description string
+	Inlinks     int
+}
+
+// BrokenLink is an internal link to a page that failed to load.
+type BrokenLink struct {
+	Source     string `json:"source"`
+	Target     string `json:"target"`
+	StatusCode int    `json:"status_code"`
+}
+
+// storedCrawl is a crawl read back from a SQLite database.
+type storedCrawl struct {
+	pages  map[string]storedPage
+	broken []BrokenLink
+}
+
+// loadStoredCrawl reads the pages and broken links of a database written
+// by writeSQLite.
+func loadStoredCrawl(path string) (*storedCrawl, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	crawl := &storedCrawl{pages: map[string]storedPage{}, broken: []BrokenLink{}}
+
+	rows, err := db.Query(`SELECT url, status_code, coalesce(title, ''), coalesce(description, ''), inlinks FROM pages`)
+	if err != nil {
+		return nil, fmt.Errorf("error reading pages from %s: %w", path, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		var page storedPage
+		if err := rows.Scan(&key, &page.StatusCode, &page.Title, &page.Description, &page.Inlinks); err != nil {
+			return nil, err
+		}
+		crawl.pages[key] = page
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	links, err := db.Query(`SELECT DISTINCT l.source, l.target_url, p.status_code
+		FROM links l JOIN pages p ON p.url = l.target
+		WHERE l.internal = 1 AND (p.status_code >= 400 OR p.status_code = 0)
+		ORDER BY l.source, l.target_url`)
+	if err != nil {
+		return nil, fmt.Errorf("error reading links from %s: %w", path, err)
+	}
+	defer links.Close()
+	for links.Next() {
+		var link BrokenLink
+		if err := links.Scan(&link.Source, &link.Target, &link.StatusCode); err != nil {
+			return nil, err
+		}
+		crawl.broken = append(crawl.broken, link)
+	}
+	return crawl, links.Err()
+}
+
+// StatusChange is a page whose HTTP status code differs between crawls.
+type StatusChange struct {
+	URL    string `json:"url"`
+	Before int    `json:"before"`
+	After  int    `json:"after"`
+}
+
+// TextChange is a page whose title or meta description differs between
+// crawls.
+type TextChange struct {
+	URL    string `json:"url"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// CountChange is a page whose number of inlinks differs between crawls.
+type CountChange struct {
+	URL    string `json:"url"`
+	Before int    `json:"before"`
+	After  int    `json:"after"`
+}
+
+// Diff lists what changed between two crawls of the same sites.
+type Diff struct {
+	NewPages           []string       `json:"new_pages"`
+	RemovedPages       []string       `json:"removed_pages"`
+	StatusChanges      []StatusChange `json:"status_changes"`
+	TitleChanges       []TextChange   `json:"title_changes"`
+	DescriptionChanges []TextChange   `json:"description_changes"`
+	InlinkChanges      []CountChange  `json:"inlink_changes"`
+	NewBrokenLinks     []BrokenLink   `json:"new_broken_links"`
+}
+
+// DiffCrawls compares two crawls stored with WriteSQLite, the older one
+// at oldPath.
+func DiffCrawls(oldPath, newPath string) (Diff, error) {
+	before, err := loadStoredCrawl(oldPath)
+	if err != nil {
+		return Diff{}, err
+	}
+	after, err := loadStoredCrawl(newPath)
+	if err != nil {
+		return Diff{}, err
+	}
+	return diffCrawls(before, after), nil
+}
+
+// diffCrawls compares an older crawl with a newer one. Title,
+// description and inlink changes are only reported for pages present in
+// both crawls.
+func diffCrawls(before, after *storedCrawl) Diff {
+	diff := Diff{
+		NewPages:           []string{},
+		RemovedPages:       []string{},
+		StatusChanges:      []StatusChange{},
+		TitleChanges:       []TextChange{},
+		DescriptionChanges: []TextChange{},
+		InlinkChanges:      []CountChange{},
+		NewBrokenLinks:     []BrokenLink{},
+	}
+
+	keys := []string{}
+	for key := range after.pages {
+		keys = append(keys, key)
+	}
+	for key := range before.pages {
+		if _, ok := after.pages[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		old, inBefore := before.pages[key]
+		cur, inAfter := after.pages[key]
+		switch {
+		case !inBefore:
+			diff.NewPages = append(diff.NewPages, key)
+			continue
+		case !inAfter:
+			diff.RemovedPages = append(diff.RemovedPages, key)
+			continue
+		}
+
+		if old.StatusCode != cur.StatusCode {
+			diff.StatusChanges = append(diff.StatusChanges, StatusChange{key, old.StatusCode, cur.StatusCode})
+		}
+		if old.Title != cur.Title {
+			diff.TitleChanges = append(diff.TitleChanges, TextChange{key, old.Title, cur.Title})
+		}
+		if old.Description != cur.Description {
+			diff.DescriptionChanges = append(diff.DescriptionChanges, TextChange{key, old.Description, cur.Description})
+		}
+		if old.Inlinks != cur.Inlinks {
+			diff.InlinkChanges = append(diff.InlinkChanges, CountChange{key, old.Inlinks, cur.Inlinks})
+		}
+	}
+
+	wasBroken := map[[2]string]bool{}
+	for _, link := range before.broken {
+		wasBroken[[2]string{link.Source, link.Target}] = true
+	}
+	for _, link := range after.broken {
+		if !wasBroken[[2]string{link.Source, link.Target}] {
+			diff.NewBrokenLinks = append(diff.NewBrokenLinks, link)
+		}
+	}
+	slices.SortFunc(diff.NewBrokenLinks, func(a, b BrokenLink) int {
+		if c := strings.Compare(a.Source, b.Source); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Target, b.Target)
+	})
+	return diff
+}
+
+// WriteText writes the diff as a plain-text report.
+func (diff Diff) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "\n\n\n=============================\n")
+	fmt.Fprintf(w, "CRAWL DIFF\n")
+	fmt.Fprintf(w, "=============================\n")
+
+	section := func(title string, count int) bool {
+		fmt.Fprintf(w, "\n%s (%d)\n", title, count)
+		return count > 0
+	}
+
+	if section("New pages", len(diff.NewPages)) {
+		for _, u := range diff.NewPages {
+			fmt.Fprintf(w, "  + %s\n", u)
+		}
+	}
+	if section("Removed pages", len(diff.RemovedPages)) {
+		for _, u := range diff.RemovedPages {
+			fmt.Fprintf(w, "  - %s\n", u)
+		}
+	}
+	if section("Status changes", len(diff.StatusChanges)) {
+		for _, c := range diff.StatusChanges {
+			fmt.Fprintf(w, "  %s: %d -> %d\n", c.URL, c.Before, c.After)
+		}
+	}
+	if section("Title changes", len(diff.TitleChanges)) {
+		for _, c := range diff.TitleChanges {
+			fmt.Fprintf(w, "  %s: %q -> %q\n", c.URL, c.Before, c.After)
+		}
+	}
+	if section("Description changes", len(diff.DescriptionChanges)) {
+		for _, c := range diff.DescriptionChanges {
+			fmt.Fprintf(w, "  %s: %q -> %q\n", c.URL, c.Before, c.After)
+		}
+	}
+	if section("Inbound link changes", len(diff.InlinkChanges)) {
+		for _, c := range diff.InlinkChanges {
+			fmt.Fprintf(w, "  %s: %d -> %d\n", c.URL, c.Before, c.After)
+		}
+	}
+	if section("New broken links", len(diff.NewBrokenLinks)) {
+		for _, l := range diff.NewBrokenLinks {
+			fmt.Fprintf(w, "  %s -> %s (%d)\n", l.Source, l.Target, l.StatusCode)
+		}
+	}
+}
+
+// WriteJSON writes the diff as indented JSON.
+func (diff Diff) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(diff)
+}
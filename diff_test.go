@@ -0,0 +1,137 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// crawlToSQLite crawls seed and stores the result in a database at path.
+func crawlToSQLite(t *testing.T, seed, path string) {
+	t.Helper()
+	c := newConfig([]string{seed}, 1, 100)
+	c.crawl(context.Background())
+	if err := c.writeSQLite(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestDiffCrawls(t *testing.T) {
+	version := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch {
+		case r.URL.Path == "/" && version == 1:
+			fmt.Fprintln(w, createHTML("Home", []string{"/a", "/b", "/d"}))
+		case r.URL.Path == "/":
+			fmt.Fprintln(w, createHTML("Home", []string{"/a", "/c", "/d"}))
+		case r.URL.Path == "/a" && version == 1:
+			fmt.Fprintln(w, `<html><head><title>A</title><meta name="description" content="first"></head></html>`)
+		case r.URL.Path == "/a":
+			fmt.Fprintln(w, `<html><head><title>A again</title><meta name="description" content="second"></head></html>`)
+		case r.URL.Path == "/b":
+			fmt.Fprintln(w, createHTML("B", []string{"/a"}))
+		case r.URL.Path == "/d" && version == 1:
+			fmt.Fprintln(w, createHTML("D", nil))
+		case r.URL.Path == "/d":
+			http.Error(w, "boom", http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	oldPath := filepath.Join(t.TempDir(), "old.db")
+	newPath := filepath.Join(t.TempDir(), "new.db")
+	crawlToSQLite(t, server.URL, oldPath)
+	version = 2
+	crawlToSQLite(t, server.URL, newPath)
+
+	before, err := loadStoredCrawl(oldPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	after, err := loadStoredCrawl(newPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	diff := diffCrawls(before, after)
+
+	host := normalizeURL(server.URL)
+	path := func(u string) string {
+		return strings.TrimPrefix(strings.TrimPrefix(u, host), server.URL)
+	}
+	paths := func(urls []string) []string {
+		out := []string{}
+		for _, u := range urls {
+			out = append(out, path(u))
+		}
+		return out
+	}
+
+	if got := paths(diff.NewPages); !reflect.DeepEqual(got, []string{"/c"}) {
+		t.Errorf("NewPages = %v; want [/c]", got)
+	}
+	if got := paths(diff.RemovedPages); !reflect.DeepEqual(got, []string{"/b"}) {
+		t.Errorf("RemovedPages = %v; want [/b]", got)
+	}
+	if len(diff.StatusChanges) != 1 || path(diff.StatusChanges[0].URL) != "/d" ||
+		diff.StatusChanges[0].Before != 200 || diff.StatusChanges[0].After != 500 {
+		t.Errorf("StatusChanges = %+v; want /d 200 -> 500", diff.StatusChanges)
+	}
+	if len(diff.TitleChanges) != 2 {
+		t.Errorf("TitleChanges = %+v; want changes for /a and /d", diff.TitleChanges)
+	}
+	if len(diff.DescriptionChanges) != 1 || diff.DescriptionChanges[0].Before != "first" || diff.DescriptionChanges[0].After != "second" {
+		t.Errorf("DescriptionChanges = %+v; want first -> second", diff.DescriptionChanges)
+	}
+	if len(diff.InlinkChanges) != 1 || path(diff.InlinkChanges[0].URL) != "/a" ||
+		diff.InlinkChanges[0].Before != 2 || diff.InlinkChanges[0].After != 1 {
+		t.Errorf("InlinkChanges = %+v; want /a 2 -> 1", diff.InlinkChanges)
+	}
+
+	broken := []string{}
+	for _, l := range diff.NewBrokenLinks {
+		broken = append(broken, fmt.Sprintf("%s %d", path(l.Target), l.StatusCode))
+	}
+	if want := []string{"/c 404", "/d 500"}; !reflect.DeepEqual(broken, want) {
+		t.Errorf("NewBrokenLinks = %v; want %v", broken, want)
+	}
+
+	t.Run("JSON", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := diff.WriteJSON(&buf); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var decoded Diff
+		if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+			t.Fatalf("invalid JSON: %v", err)
+		}
+		if !reflect.DeepEqual(decoded, diff) {
+			t.Errorf("JSON round trip = %+v; want %+v", decoded, diff)
+		}
+	})
+
+	t.Run("Text", func(t *testing.T) {
+		var buf bytes.Buffer
+		diff.WriteText(&buf)
+		for _, want := range []string{"New pages (1)", "Removed pages (1)", "200 -> 500", `"first" -> "second"`, "New broken links (2)"} {
+			if !strings.Contains(buf.String(), want) {
+				t.Errorf("text diff is missing %q:\n%s", want, buf.String())
+			}
+		}
+	})
+}
+
+func TestLoadStoredCrawl_MissingFile(t *testing.T) {
+	if _, err := loadStoredCrawl(filepath.Join(t.TempDir(), "missing.db")); err == nil {
+		t.Errorf("expected an error for a missing database")
+	}
+}
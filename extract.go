@@ -0,0 +1,263 @@
+package crawler
+
+import (
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// pageExtract is everything pulled out of a page in a single pass.
+type pageExtract struct {
+	links     []string         // absolute URLs of every <a href>
+	canonical string           // absolute rel=canonical URL, empty if none
+	robots    robotsDirectives // from robots and bot-specific meta tags
+	meta      PageMetadata
+	content   string // hex SHA-256 of the main text, empty if there is none
+	simhash   uint64 // SimHash of the main text
+}
+
+// PageMetadata is the descriptive information found in a page, kept on
+// the page result for reports and exports. Text values have their
+// whitespace collapsed.
+type PageMetadata struct {
+	Title       string
+	Description string
+	Robots      string // content of <meta name="robots">
+	H1          []string
+	H1Count     int // <h1> elements; H1 lists only the first when loaded from an old crawl database
+	H2          []string
+	H3          []string
+	Lang        string            // lang attribute of <html>
+	OpenGraph   map[string]string // og:* properties, keyed by property
+	Twitter     map[string]string // twitter:* cards, keyed by name
+	WordCount   int               // words of visible body text
+}
+
+// Elements whose text is never shown to the reader.
+var invisibleElements = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"noscript": true,
+	"template": true,
+	"head":     true,
+	"title":    true,
+}
+
+// extractPage tokenizes the HTML read from r and collects links and page
+// signals without building a document tree, so memory use stays flat no
+// matter how large or deeply nested the page is. Links are resolved
+// against the page's <base href> when it declares one, and against
+// rawPageURL otherwise; the canonical URL always against rawPageURL.
+func extractPage(r io.Reader, rawPageURL string) (*pageExtract, error) {
+	result := &pageExtract{links: []string{}}
+	meta := &result.meta
+
+	pageURL, pageErr := url.Parse(rawPageURL)
+	if pageErr != nil {
+		pageURL = nil
+	}
+	baseURL := pageURL
+	seenBase := false
+
+	// Text is gathered for the element currently being captured (title
+	// or a heading); invisible counts the open elements hiding text and
+	// boilerplate those whose text is not part of the main text.
+	var capturing string
+	var captured strings.Builder
+	invisible := 0
+	boilerplate := 0
+	seenTitle := false
+	mainText := newContentHasher()
+
+	z := html.NewTokenizer(r)
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if errors.Is(z.Err(), io.EOF) {
+				break
+			}
+			result.content, result.simhash = mainText.sums()
+			return result, z.Err()
+		}
+
+		switch tt {
+		case html.TextToken:
+			text := string(z.Text())
+			if capturing != "" {
+				captured.WriteString(text)
+			}
+			if invisible == 0 {
+				meta.WordCount += len(strings.Fields(text))
+				if boilerplate == 0 {
+					mainText.add(text)
+				}
+			}
+			continue
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			tag := string(name)
+			if invisibleElements[tag] && invisible > 0 {
+				invisible--
+			}
+			if boilerplateElements[tag] && boilerplate > 0 {
+				boilerplate--
+			}
+			if tag == capturing {
+				text := strings.Join(strings.Fields(captured.String()), " ")
+				switch tag {
+				case "title":
+					meta.Title = text
+				case "h1":
+					meta.H1 = append(meta.H1, text)
+					meta.H1Count++
+				case "h2":
+					meta.H2 = append(meta.H2, text)
+				case "h3":
+					meta.H3 = append(meta.H3, text)
+				}
+				capturing = ""
+				captured.Reset()
+			}
+			continue
+		case html.StartTagToken, html.SelfClosingTagToken:
+		default:
+			continue
+		}
+
+		name, hasAttr := z.TagName()
+		tag := string(name)
+
+		if tt == html.StartTagToken {
+			switch tag {
+			case "body":
+				// A missing </head> must not hide the whole body.
+				invisible = 0
+			case "title":
+				if !seenTitle {
+					seenTitle = true
+					capturing = tag
+					captured.Reset()
+				}
+			case "h1", "h2", "h3":
+				if capturing == "" {
+					capturing = tag
+					captured.Reset()
+				}
+			}
+			if invisibleElements[tag] {
+				invisible++
+			}
+			if boilerplateElements[tag] {
+				boilerplate++
+			}
+		}
+
+		if !hasAttr {
+			continue
+		}
+
+		switch tag {
+		case "base":
+			// Only the first <base href> counts.
+			href, ok := tokenAttrs(z)["href"]
+			if !ok || seenBase {
+				continue
+			}
+			seenBase = true
+			if ref, err := url.Parse(strings.TrimSpace(href)); err == nil && pageURL != nil {
+				baseURL = pageURL.ResolveReference(ref)
+			}
+		case "html":
+			if lang, ok := tokenAttrs(z)["lang"]; ok && meta.Lang == "" {
+				meta.Lang = strings.TrimSpace(lang)
+			}
+		case "a":
+			attrs := tokenAttrs(z)
+			href, ok := attrs["href"]
+			if !ok {
+				continue
+			}
+			if link, ok := resolveLink(baseURL, href); ok {
+				result.links = append(result.links, link)
+			}
+		case "link":
+			attrs := tokenAttrs(z)
+			if result.canonical == "" && strings.EqualFold(strings.TrimSpace(attrs["rel"]), "canonical") {
+				result.canonical = strings.TrimSpace(attrs["href"])
+				if pageURL != nil {
+					if ref, err := url.Parse(result.canonical); err == nil {
+						result.canonical = pageURL.ResolveReference(ref).String()
+					}
+				}
+			}
+		case "meta":
+			attrs := tokenAttrs(z)
+			content := strings.Join(strings.Fields(attrs["content"]), " ")
+			name := strings.ToLower(strings.TrimSpace(attrs["name"]))
+			property := strings.ToLower(strings.TrimSpace(attrs["property"]))
+			switch {
+			case name == "description":
+				meta.Description = content
+			case isRobotsMetaName(name):
+				if name == "robots" {
+					meta.Robots = content
+				}
+				result.robots = result.robots.merge(parseRobotsDirectives(content))
+			case strings.HasPrefix(property, "og:"):
+				if meta.OpenGraph == nil {
+					meta.OpenGraph = map[string]string{}
+				}
+				meta.OpenGraph[property] = content
+			case strings.HasPrefix(name, "twitter:") || strings.HasPrefix(property, "twitter:"):
+				if meta.Twitter == nil {
+					meta.Twitter = map[string]string{}
+				}
+				key := name
+				if key == "" {
+					key = property
+				}
+				meta.Twitter[key] = content
+			}
+		}
+	}
+
+	result.content, result.simhash = mainText.sums()
+	if pageErr != nil {
+		return result, errors.New("the base link was not valid")
+	}
+	return result, nil
+}
+
+// tokenAttrs returns the attributes of the current tag, keyed by their
+// lowercased name. The first occurrence of a repeated attribute wins.
+func tokenAttrs(z *html.Tokenizer) map[string]string {
+	attrs := map[string]string{}
+	for {
+		key, val, more := z.TagAttr()
+		if _, seen := attrs[string(key)]; !seen {
+			attrs[string(key)] = string(val)
+		}
+		if !more {
+			return attrs
+		}
+	}
+}
+
+// resolveLink turns an href into an absolute URL. Links with a host are
+// kept as they are; the rest are resolved against base.
+func resolveLink(base *url.URL, href string) (string, bool) {
+	u, err := url.Parse(href)
+	if err != nil {
+		return "", false
+	}
+	if u.Host != "" {
+		return u.String(), true
+	}
+	if base == nil {
+		return "", false
+	}
+	return base.ResolveReference(u).String(), true
+}
@@ -0,0 +1,329 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Fetcher retrieves the pages the crawler visits. Implementations
+// answer with whatever the server sent, non-2xx statuses included, and
+// return an error only when no response was obtained. The caller closes
+// the response body.
+type Fetcher interface {
+	Fetch(ctx context.Context, req *FetchRequest) (*FetchResponse, error)
+}
+
+// FetcherFunc adapts an ordinary function to the Fetcher interface.
+type FetcherFunc func(ctx context.Context, req *FetchRequest) (*FetchResponse, error)
+
+// Fetch calls f(ctx, req).
+func (f FetcherFunc) Fetch(ctx context.Context, req *FetchRequest) (*FetchResponse, error) {
+	return f(ctx, req)
+}
+
+// FetchRequest asks for one page.
+type FetchRequest struct {
+	URL    string
+	Header http.Header // extra request headers, may be nil
+}
+
+// FetchResponse is what came back for a FetchRequest, after following
+// redirects.
+type FetchResponse struct {
+	URL           string        // URL that was requested
+	FinalURL      string        // URL the response came from
+	StatusCode    int           // e.g. 200
+	Status        string        // e.g. "200 OK"
+	Header        http.Header   // response headers
+	ContentLength int64         // -1 when unknown
+	Body          io.ReadCloser // body as sent, still content encoded
+	Redirects     []Redirect    // redirects followed to reach FinalURL
+}
+
+// HTTPFetcher is the default Fetcher, making requests with net/http and
+// following up to maxRedirects redirects.
+type HTTPFetcher struct {
+	Transport http.RoundTripper // nil for http.DefaultTransport
+}
+
+// Fetch sends a GET request for req.URL with req.Header added, recording
+// every redirect it follows.
+func (f *HTTPFetcher) Fetch(ctx context.Context, req *FetchRequest) (*FetchResponse, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", req.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range req.Header {
+		httpReq.Header[name] = values
+	}
+
+	redirects := []Redirect{}
+	client := &http.Client{
+		Transport: f.Transport,
+		CheckRedirect: func(next *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			redirects = append(redirects, Redirect{
+				From:       via[len(via)-1].URL.String(),
+				To:         next.URL.String(),
+				StatusCode: next.Response.StatusCode,
+			})
+			return nil
+		},
+	}
+
+	res, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	return &FetchResponse{
+		URL:           req.URL,
+		FinalURL:      res.Request.URL.String(),
+		StatusCode:    res.StatusCode,
+		Status:        res.Status,
+		Header:        res.Header,
+		ContentLength: res.ContentLength,
+		Body:          res.Body,
+		Redirects:     redirects,
+	}, nil
+}
+
+// FetcherMiddleware wraps a Fetcher to add behaviour around every fetch.
+type FetcherMiddleware func(Fetcher) Fetcher
+
+// ChainFetcher wraps f in middlewares, the first one outermost.
+func ChainFetcher(f Fetcher, middlewares ...FetcherMiddleware) Fetcher {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		f = middlewares[i](f)
+	}
+	return f
+}
+
+// WithLogging logs every fetch to logger: the status and how long it
+// took at info level, or why it failed as a warning.
+func WithLogging(logger *slog.Logger) FetcherMiddleware {
+	return func(next Fetcher) Fetcher {
+		return FetcherFunc(func(ctx context.Context, req *FetchRequest) (*FetchResponse, error) {
+			start := time.Now()
+			res, err := next.Fetch(ctx, req)
+			elapsed := time.Since(start).Round(time.Millisecond)
+			if err != nil {
+				logger.Warn("fetch failed", "url", req.URL, "duration", elapsed, "error", err)
+				return nil, err
+			}
+			logger.Info("fetched", "url", req.URL, "status", res.StatusCode, "duration", elapsed)
+			return res, nil
+		})
+	}
+}
+
+// WithRetry retries fetches that fail on a network error or time out, or
+// are answered with 429 or a 5xx status, up to attempts tries in all. The
+// wait starts at backoff and doubles after every try. Nothing is retried
+// once ctx is done.
+func WithRetry(attempts int, backoff time.Duration) FetcherMiddleware {
+	return func(next Fetcher) Fetcher {
+		return FetcherFunc(func(ctx context.Context, req *FetchRequest) (*FetchResponse, error) {
+			wait := backoff
+			for attempt := 1; ; attempt++ {
+				res, err := next.Fetch(ctx, req)
+				if attempt >= attempts || ctx.Err() != nil || !retryable(res, err) {
+					return res, err
+				}
+				if res != nil {
+					res.Body.Close()
+				}
+
+				select {
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				case <-time.After(wait):
+				}
+				wait *= 2
+			}
+		})
+	}
+}
+
+// retryable reports whether another try might get a better answer. Errors
+// that will happen again, such as a malformed URL, a bad certificate or
+// too many redirects, are final.
+func retryable(res *FetchResponse, err error) bool {
+	if err == nil {
+		return res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		// A TLS alert from the server comes back as a "remote error".
+		return opErr.Op != "remote error"
+	}
+	// The connection was dropped before a full response arrived.
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// FetchCache keeps successful responses in memory, keyed by URL.
+type FetchCache struct {
+	mu          sync.Mutex
+	entries     map[string]*cachedResponse
+	maxBodySize int64
+}
+
+type cachedResponse struct {
+	res  FetchResponse // Body is nil
+	body []byte
+}
+
+// NewFetchCache returns an empty cache that keeps bodies of up to
+// maxBodySize bytes, or of up to the crawler's default body limit when
+// maxBodySize is 0.
+func NewFetchCache(maxBodySize int64) *FetchCache {
+	if maxBodySize <= 0 {
+		maxBodySize = defaultFetchLimits.maxBodySize
+	}
+	return &FetchCache{entries: map[string]*cachedResponse{}, maxBodySize: maxBodySize}
+}
+
+// Len returns the number of cached responses.
+func (c *FetchCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// WithCache answers repeated requests for a URL from cache instead of
+// fetching it again. Only 200 responses to unconditional requests are
+// cached, with their whole body; bodies over the cache's size limit are
+// passed on as they are, without being read in full.
+func WithCache(cache *FetchCache) FetcherMiddleware {
+	return func(next Fetcher) Fetcher {
+		return FetcherFunc(func(ctx context.Context, req *FetchRequest) (*FetchResponse, error) {
+			conditional := req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != ""
+			if !conditional {
+				cache.mu.Lock()
+				entry, ok := cache.entries[req.URL]
+				cache.mu.Unlock()
+				if ok {
+					res := entry.res
+					res.Body = io.NopCloser(bytes.NewReader(entry.body))
+					return &res, nil
+				}
+			}
+
+			res, err := next.Fetch(ctx, req)
+			if err != nil || conditional || res.StatusCode != http.StatusOK {
+				return res, err
+			}
+
+			body, err := io.ReadAll(io.LimitReader(res.Body, cache.maxBodySize+1))
+			if err != nil {
+				res.Body.Close()
+				return nil, err
+			}
+			if int64(len(body)) > cache.maxBodySize {
+				res.Body = struct {
+					io.Reader
+					io.Closer
+				}{io.MultiReader(bytes.NewReader(body), res.Body), res.Body}
+				return res, nil
+			}
+			res.Body.Close()
+			entry := &cachedResponse{res: *res, body: body}
+			entry.res.Body = nil
+			cache.mu.Lock()
+			cache.entries[req.URL] = entry
+			cache.mu.Unlock()
+
+			res.Body = io.NopCloser(bytes.NewReader(body))
+			return res, nil
+		})
+	}
+}
+
+// FetchMetrics counts what went through a WithMetrics fetcher. It is
+// safe to read while fetches are running.
+type FetchMetrics struct {
+	Requests atomic.Int64 // fetches attempted
+	Errors   atomic.Int64 // fetches that got no response
+	Bytes    atomic.Int64 // body bytes read, as sent on the wire
+	Duration atomic.Int64 // total time to response headers, in nanoseconds
+
+	mu       sync.Mutex
+	statuses map[int]int64
+}
+
+// StatusCounts returns how many responses came back with each status.
+func (m *FetchMetrics) StatusCounts() map[int]int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counts := make(map[int]int64, len(m.statuses))
+	for status, n := range m.statuses {
+		counts[status] = n
+	}
+	return counts
+}
+
+// String summarizes the metrics on one line.
+func (m *FetchMetrics) String() string {
+	requests := m.Requests.Load()
+	avg := time.Duration(0)
+	if requests > 0 {
+		avg = time.Duration(m.Duration.Load() / requests).Round(time.Millisecond)
+	}
+	return fmt.Sprintf("%d requests, %d errors, %s read, %s average response time",
+		requests, m.Errors.Load(), formatBytes(int(m.Bytes.Load())), avg)
+}
+
+// WithMetrics records every fetch in m.
+func WithMetrics(m *FetchMetrics) FetcherMiddleware {
+	return func(next Fetcher) Fetcher {
+		return FetcherFunc(func(ctx context.Context, req *FetchRequest) (*FetchResponse, error) {
+			start := time.Now()
+			res, err := next.Fetch(ctx, req)
+			m.Requests.Add(1)
+			m.Duration.Add(int64(time.Since(start)))
+			if err != nil {
+				m.Errors.Add(1)
+				return nil, err
+			}
+
+			m.mu.Lock()
+			if m.statuses == nil {
+				m.statuses = map[int]int64{}
+			}
+			m.statuses[res.StatusCode]++
+			m.mu.Unlock()
+
+			res.Body = &meteredBody{ReadCloser: res.Body, bytes: &m.Bytes}
+			return res, nil
+		})
+	}
+}
+
+type meteredBody struct {
+	io.ReadCloser
+	bytes *atomic.Int64
+}
+
+func (b *meteredBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.bytes.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,237 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestHTTPFetcher(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/new", http.StatusFound)
+		case "/new":
+			fmt.Fprint(w, "X-Test="+r.Header.Get("X-Test"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	f := &HTTPFetcher{}
+	res, err := f.Fetch(context.Background(), &FetchRequest{URL: server.URL + "/old", Header: http.Header{"X-Test": {"yes"}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != 200 || res.FinalURL != server.URL+"/new" || string(body) != "X-Test=yes" {
+		t.Errorf("Fetch() = %d %s %q; want 200 from /new with the request header", res.StatusCode, res.FinalURL, body)
+	}
+	wantHops := []Redirect{{From: server.URL + "/old", To: server.URL + "/new", StatusCode: http.StatusFound}}
+	if !reflect.DeepEqual(res.Redirects, wantHops) {
+		t.Errorf("Redirects = %+v; want %+v", res.Redirects, wantHops)
+	}
+
+	// Error statuses are responses, not errors.
+	res, err = f.Fetch(context.Background(), &FetchRequest{URL: server.URL + "/missing"})
+	if err != nil || res.StatusCode != http.StatusNotFound || res.Status != "404 Not Found" {
+		t.Errorf("Fetch() = %+v, %v; want a 404 response", res, err)
+	}
+	res.Body.Close()
+}
+
+// stubFetcher answers from a fixed list of statuses, one per call, and
+// counts the calls.
+type stubFetcher struct {
+	statuses []int // 0 fails the fetch with a network error, -1 with any other
+	calls    atomic.Int32
+}
+
+func (s *stubFetcher) Fetch(ctx context.Context, req *FetchRequest) (*FetchResponse, error) {
+	n := int(s.calls.Add(1)) - 1
+	status := s.statuses[min(n, len(s.statuses)-1)]
+	switch status {
+	case 0:
+		return nil, &url.Error{Op: "Get", URL: req.URL, Err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}}
+	case -1:
+		return nil, &url.Error{Op: "Get", URL: req.URL, Err: errors.New("stopped after 10 redirects")}
+	}
+	body := fmt.Sprintf("response %d", n)
+	return &FetchResponse{
+		URL:           req.URL,
+		FinalURL:      req.URL,
+		StatusCode:    status,
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Header:        http.Header{"Content-Type": {"text/html"}},
+		ContentLength: int64(len(body)),
+		Body:          io.NopCloser(strings.NewReader(body)),
+	}, nil
+}
+
+func TestWithRetry(t *testing.T) {
+	tests := []struct {
+		name       string
+		statuses   []int
+		attempts   int
+		wantCalls  int32
+		wantStatus int // 0 for an error
+	}{
+		{"Success first time", []int{200}, 3, 1, 200},
+		{"Error then success", []int{0, 200}, 3, 2, 200},
+		{"Server errors then success", []int{503, 429, 200}, 3, 3, 200},
+		{"Gives up", []int{500}, 3, 3, 500},
+		{"Gives up on errors", []int{0}, 2, 2, 0},
+		{"No retry on 404", []int{404, 200}, 3, 1, 404},
+		{"No retry on other errors", []int{-1, 200}, 3, 1, 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			stub := &stubFetcher{statuses: tc.statuses}
+			f := ChainFetcher(stub, WithRetry(tc.attempts, time.Millisecond))
+			res, err := f.Fetch(context.Background(), &FetchRequest{URL: "https://example.com"})
+			if got := stub.calls.Load(); got != tc.wantCalls {
+				t.Errorf("calls = %d; want %d", got, tc.wantCalls)
+			}
+			if tc.wantStatus == 0 {
+				if err == nil {
+					t.Errorf("expected an error, got %d", res.StatusCode)
+				}
+				return
+			}
+			if err != nil || res.StatusCode != tc.wantStatus {
+				t.Errorf("Fetch() = %v, %v; want status %d", res, err, tc.wantStatus)
+			}
+		})
+	}
+
+	t.Run("Cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		stub := &stubFetcher{statuses: []int{500}}
+		f := ChainFetcher(stub, WithRetry(3, time.Hour))
+		res, err := f.Fetch(ctx, &FetchRequest{URL: "https://example.com"})
+		if err != nil || res.StatusCode != 500 || stub.calls.Load() != 1 {
+			t.Errorf("Fetch() = %v, %v after %d calls; want the first answer, not retried", res, err, stub.calls.Load())
+		}
+	})
+}
+
+func TestWithCache(t *testing.T) {
+	stub := &stubFetcher{statuses: []int{200, 200, 200}}
+	cache := NewFetchCache(0)
+	f := ChainFetcher(stub, WithCache(cache))
+
+	read := func(req *FetchRequest) string {
+		res, err := f.Fetch(context.Background(), req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return string(body)
+	}
+
+	first := read(&FetchRequest{URL: "https://example.com/a"})
+	second := read(&FetchRequest{URL: "https://example.com/a"})
+	if first != "response 0" || second != first || stub.calls.Load() != 1 {
+		t.Errorf("bodies %q, %q after %d calls; want the first response twice from one call", first, second, stub.calls.Load())
+	}
+
+	conditional := read(&FetchRequest{URL: "https://example.com/a", Header: http.Header{"If-None-Match": {`"v1"`}}})
+	if conditional != "response 1" {
+		t.Errorf("conditional request got %q; want a fresh response", conditional)
+	}
+	if cache.Len() != 1 {
+		t.Errorf("Len() = %d; want 1", cache.Len())
+	}
+
+	t.Run("Too large", func(t *testing.T) {
+		stub := &stubFetcher{statuses: []int{200}}
+		cache := NewFetchCache(4)
+		f := ChainFetcher(stub, WithCache(cache))
+		for i := range 2 {
+			res, err := f.Fetch(context.Background(), &FetchRequest{URL: "https://example.com/big"})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			body, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			if want := fmt.Sprintf("response %d", i); string(body) != want {
+				t.Errorf("body = %q; want the whole %q", body, want)
+			}
+		}
+		if cache.Len() != 0 || stub.calls.Load() != 2 {
+			t.Errorf("Len() = %d after %d calls; want nothing cached", cache.Len(), stub.calls.Load())
+		}
+	})
+}
+
+func TestWithMetricsAndLogging(t *testing.T) {
+	var log bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&log, nil))
+	metrics := &FetchMetrics{}
+	f := ChainFetcher(&stubFetcher{statuses: []int{200, 404, 0}}, WithLogging(logger), WithMetrics(metrics))
+
+	for range 3 {
+		res, err := f.Fetch(context.Background(), &FetchRequest{URL: "https://example.com"})
+		if err == nil {
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+		}
+	}
+
+	if metrics.Requests.Load() != 3 || metrics.Errors.Load() != 1 || metrics.Bytes.Load() != int64(len("response 0")*2) {
+		t.Errorf("metrics = %s, %d bytes; want 3 requests, 1 error, 20 bytes", metrics, metrics.Bytes.Load())
+	}
+	if want := map[int]int64{200: 1, 404: 1}; !reflect.DeepEqual(metrics.StatusCounts(), want) {
+		t.Errorf("StatusCounts() = %v; want %v", metrics.StatusCounts(), want)
+	}
+
+	lines := strings.Split(strings.TrimSpace(log.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[0], "status=200") || !strings.Contains(lines[2], "level=WARN msg=\"fetch failed\"") {
+		t.Errorf("log = %q; want one line per fetch", log.String())
+	}
+}
+
+func TestCrawl_CustomFetcher(t *testing.T) {
+	pages := map[string]string{
+		"https://example.com":       createHTML("Home", []string{"/about"}),
+		"https://example.com/about": createHTML("About", []string{"/"}),
+	}
+	c := newConfig([]string{"https://example.com"}, 1, 100)
+	c.fetcher = FetcherFunc(func(ctx context.Context, req *FetchRequest) (*FetchResponse, error) {
+		body, ok := pages[req.URL]
+		if !ok {
+			return nil, fmt.Errorf("unexpected fetch of %s", req.URL)
+		}
+		return &FetchResponse{
+			URL: req.URL, FinalURL: req.URL, StatusCode: 200, Status: "200 OK",
+			Header:        http.Header{"Content-Type": {"text/html"}},
+			ContentLength: -1,
+			Body:          io.NopCloser(strings.NewReader(body)),
+		}, nil
+	})
+	c.crawl(context.Background())
+
+	for _, key := range []string{"example.com", "example.com/about"} {
+		if page, ok := c.result(key); !ok || page.Err != "" || page.StatusCode != 200 {
+			t.Errorf("result for %s = %+v; want a crawled page", key, page)
+		}
+	}
+}
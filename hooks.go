@@ -0,0 +1,207 @@
+package crawler
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/html"
+)
+
+// Hooks are callbacks run as the crawl makes progress. Any of them may be
+// nil. They are called from the crawl's workers, several at a time, so
+// they must be safe for concurrent use, and they hold up the worker that
+// calls them until they return. Pages passed to hooks are the stored
+// results and must not be modified.
+type Hooks struct {
+	// OnRequest is called before a page is fetched, and may change the
+	// request's headers. A non-nil error cancels the fetch; the page is
+	// stored with that error and OnError is called.
+	OnRequest func(req *FetchRequest) error
+
+	// OnResponse is called once the response headers arrive. The body
+	// is still to be read by the crawler and must be left alone.
+	OnResponse func(res *FetchResponse)
+
+	// OnPage is called for every page fetched successfully, once it is
+	// stored. doc is nil for pages unchanged since the previous crawl.
+	// Pages are otherwise streamed, so setting OnPage makes the crawler
+	// keep each body in memory while it is parsed.
+	OnPage func(page *Page, doc *Document)
+
+	// OnLink is called for every link found on page before it is queued;
+	// returning false keeps it out of the crawl.
+	OnLink func(page *Page, link string) bool
+
+	// OnError is called for every page that could not be fetched, once
+	// it is stored.
+	OnError func(page *Page, err error)
+
+	// OnFinish is called when the crawl stops, whether it ran out of
+	// pages or was cancelled.
+	OnFinish func(results *Results)
+
+	// skipDocument is set on the crawler's own hooks whose OnPage never
+	// looks at doc, so bodies need not be kept for them.
+	skipDocument bool
+}
+
+// Document is the HTML of a fetched page, decoded to UTF-8.
+type Document struct {
+	URL  string // final URL of the page
+	Body string
+}
+
+// Parse parses the document into a tree. The crawler itself only
+// tokenizes pages, so this is done on demand.
+func (d *Document) Parse() (*html.Node, error) {
+	return html.Parse(strings.NewReader(d.Body))
+}
+
+// hookSet runs every registered Hooks in turn.
+type hookSet []Hooks
+
+func (hs hookSet) request(req *FetchRequest) error {
+	for _, h := range hs {
+		if h.OnRequest != nil {
+			if err := h.OnRequest(req); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (hs hookSet) response(res *FetchResponse) {
+	for _, h := range hs {
+		if h.OnResponse != nil {
+			h.OnResponse(res)
+		}
+	}
+}
+
+// wantDocument reports whether some OnPage hook needs the page body.
+func (hs hookSet) wantDocument() bool {
+	for _, h := range hs {
+		if h.OnPage != nil && !h.skipDocument {
+			return true
+		}
+	}
+	return false
+}
+
+func (hs hookSet) page(page *Page, doc *Document) {
+	for _, h := range hs {
+		if h.OnPage != nil {
+			h.OnPage(page, doc)
+		}
+	}
+}
+
+// link reports whether every OnLink hook lets the link be queued.
+func (hs hookSet) link(page *Page, link string) bool {
+	for _, h := range hs {
+		if h.OnLink != nil && !h.OnLink(page, link) {
+			return false
+		}
+	}
+	return true
+}
+
+func (hs hookSet) error(page *Page, err error) {
+	for _, h := range hs {
+		if h.OnError != nil {
+			h.OnError(page, err)
+		}
+	}
+}
+
+func (hs hookSet) finish(results *Results) {
+	for _, h := range hs {
+		if h.OnFinish != nil {
+			h.OnFinish(results)
+		}
+	}
+}
+
+// middleware runs the OnRequest and OnResponse hooks around each fetch.
+// It is the outermost middleware, so retries do not call them again.
+func (hs hookSet) middleware(next Fetcher) Fetcher {
+	return FetcherFunc(func(ctx context.Context, req *FetchRequest) (*FetchResponse, error) {
+		if err := hs.request(req); err != nil {
+			return nil, err
+		}
+		res, err := next.Fetch(ctx, req)
+		if err == nil {
+			hs.response(res)
+		}
+		return res, err
+	})
+}
+
+// EventType says what an Event reports.
+type EventType string
+
+// Types of events sent to a WithEvents channel, one per hook.
+const (
+	EventRequest  EventType = "request"
+	EventResponse EventType = "response"
+	EventPage     EventType = "page"
+	EventLink     EventType = "link"
+	EventError    EventType = "error"
+	EventFinish   EventType = "finish"
+)
+
+// Event is a hook call delivered over a channel instead.
+type Event struct {
+	Type       EventType
+	URL        string // requested URL, page URL or the link found
+	StatusCode int    // response status, for response events
+	Page       *Page  // the page, or the page a link was found on
+	Err        error  // why the page failed, for error events
+}
+
+// eventSink sends the crawl's events to a channel until the crawl's
+// context is done.
+type eventSink struct {
+	ch   chan<- Event
+	done <-chan struct{} // set by Run
+	once sync.Once
+}
+
+func (s *eventSink) send(e Event) {
+	select {
+	case s.ch <- e:
+	case <-s.done:
+	}
+}
+
+func (s *eventSink) close() {
+	s.once.Do(func() { close(s.ch) })
+}
+
+func (s *eventSink) hooks() Hooks {
+	return Hooks{
+		OnRequest: func(req *FetchRequest) error {
+			s.send(Event{Type: EventRequest, URL: req.URL})
+			return nil
+		},
+		OnResponse: func(res *FetchResponse) {
+			s.send(Event{Type: EventResponse, URL: res.URL, StatusCode: res.StatusCode})
+		},
+		OnPage: func(page *Page, doc *Document) {
+			s.send(Event{Type: EventPage, URL: page.URL, StatusCode: page.StatusCode, Page: page})
+		},
+		OnLink: func(page *Page, link string) bool {
+			s.send(Event{Type: EventLink, URL: link, Page: page})
+			return true
+		},
+		OnError: func(page *Page, err error) {
+			s.send(Event{Type: EventError, URL: page.URL, StatusCode: page.StatusCode, Page: page, Err: err})
+		},
+		OnFinish: func(results *Results) {
+			s.send(Event{Type: EventFinish})
+		},
+		skipDocument: true,
+	}
+}
@@ -0,0 +1,153 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func hooksTestServer() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, createHTML("Home", []string{"/a", "/b", "/private/c"}))
+		case "/a", "/b", "/private/c":
+			fmt.Fprint(w, createHTML(r.URL.Path, nil))
+		default:
+			http.NotFound(w, r)
+		}
+	}
+}
+
+func TestHooks(t *testing.T) {
+	var gotAgent string
+	server := createMockServer(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/a" {
+			gotAgent = r.Header.Get("User-Agent")
+		}
+		hooksTestServer()(w, r)
+	})
+	defer server.Close()
+
+	var mu sync.Mutex
+	var requests, responses, pages, links, finished []string
+	var failed []error
+	record := func(list *[]string, s string) {
+		mu.Lock()
+		defer mu.Unlock()
+		*list = append(*list, s)
+	}
+
+	errVetoed := errors.New("vetoed")
+	hooks := Hooks{
+		OnRequest: func(req *FetchRequest) error {
+			record(&requests, req.URL)
+			if strings.HasSuffix(req.URL, "/b") {
+				return errVetoed
+			}
+			req.Header.Set("User-Agent", "hooks-test")
+			return nil
+		},
+		OnResponse: func(res *FetchResponse) {
+			record(&responses, res.URL)
+		},
+		OnPage: func(page *Page, doc *Document) {
+			if doc == nil {
+				t.Errorf("OnPage(%s) got no document", page.URL)
+				return
+			}
+			node, err := doc.Parse()
+			if err != nil || node == nil {
+				t.Errorf("parsing %s: %v", page.URL, err)
+			}
+			record(&pages, page.URL)
+		},
+		OnLink: func(page *Page, link string) bool {
+			record(&links, link)
+			return !strings.Contains(link, "/private/")
+		},
+		OnError: func(page *Page, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			failed = append(failed, err)
+		},
+		OnFinish: func(results *Results) {
+			record(&finished, fmt.Sprint(len(results.Pages())))
+		},
+	}
+
+	c, err := New([]string{server.URL}, WithHooks(hooks))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer c.Close()
+	if _, err := c.Run(context.Background()); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if len(requests) != 3 {
+		t.Errorf("OnRequest called for %v, want 3 pages", requests)
+	}
+	if len(responses) != 2 {
+		t.Errorf("OnResponse called for %v, want 2 pages", responses)
+	}
+	if len(pages) != 2 {
+		t.Errorf("OnPage called for %v, want 2 pages", pages)
+	}
+	if len(links) != 3 {
+		t.Errorf("OnLink called for %v, want 3 links", links)
+	}
+	if len(failed) != 1 || !errors.Is(failed[0], errVetoed) {
+		t.Errorf("OnError got %v, want the veto", failed)
+	}
+	if len(finished) != 1 || finished[0] != "3" {
+		t.Errorf("OnFinish got %v, want one call with 3 pages", finished)
+	}
+	if gotAgent != "hooks-test" {
+		t.Errorf("User-Agent = %q, want the one set by OnRequest", gotAgent)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	server := createMockServer(hooksTestServer())
+	defer server.Close()
+
+	events := make(chan Event)
+	c, err := New([]string{server.URL}, WithEvents(events))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer c.Close()
+
+	done := make(chan error)
+	go func() {
+		_, err := c.Run(context.Background())
+		done <- err
+	}()
+
+	counts := map[EventType]int{}
+	for e := range events {
+		counts[e.Type]++
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	want := map[EventType]int{
+		EventRequest:  4,
+		EventResponse: 4,
+		EventPage:     4,
+		EventLink:     3,
+		EventFinish:   1,
+	}
+	for typ, n := range want {
+		if counts[typ] != n {
+			t.Errorf("got %d %s events, want %d", counts[typ], typ, n)
+		}
+	}
+}
@@ -1,19 +1,18 @@
-package main
+package crawler
 
 import (
+	"context"
 	"errors"
-	"io"
-	"slices"
+	"log/slog"
 	"sort"
 
-	//"io"
+	"io"
 	"net/url"
 	"strings"
+	"time"
 
 	"fmt"
 	"net/http"
-
-	"golang.org/x/net/html"
 )
 
 // normalizeURL takes a URL string and returns a normalized version
@@ -80,105 +79,114 @@ func normalizeURL(rawURL string) string {
 	return strings.ToLower(host + path)
 }
 
-func getLinks(node *html.Node) []string {
-	//fmt.Printf("node type: %v, node data: %v\n", node.Type, node.Data)
-	links := []string{}
-	if node.Type == html.ElementNode && node.Data == "a" {
-		//fmt.Println("Econtré uno!")
-		for _, attr := range node.Attr {
-			if attr.Key == "href" {
-				links = append(links, attr.Val)
-			}
-		}
-	}
-
-	for child := range node.ChildNodes() {
-		//fmt.Printf("***childs nodes**** type: %v, data: %v\n", child.Type, child.Data)
-		child_links := getLinks(child)
-		if len(child_links) > 0 {
-			links = slices.Concat(links, child_links)
-		}
-	}
-
-	return links
+// fetchResult is the outcome of a single page request. It is returned
+// even when the request fails with a non-2xx status, so callers can
+// record the status code and headers of broken pages.
+type fetchResult struct {
+	body        io.Reader // UTF-8 body streamed from the response, nil unless the fetch succeeded
+	raw         *bodyReader
+	statusCode  int
+	header      http.Header
+	finalURL    string
+	charset     string     // charset the body is transcoded from
+	redirects   []Redirect // redirects followed to reach finalURL
+	notModified bool       // 304 answer to a conditional request
 }
 
-func getURLsFromHTML(htmlBody, rawBaseURL string) ([]string, error) {
-	//fmt.Println("I am here")
-
-	reader := strings.NewReader(htmlBody)
-	htmlNode, err := html.Parse(reader)
-	baseError := false
-	linkError := false
+// truncated reports whether the body read so far was cut at the size
+// limit.
+func (r *fetchResult) truncated() bool {
+	return r.raw != nil && r.raw.truncated
+}
 
-	if err != nil {
-		fmt.Println("Error, no se pudo parsear")
-		return []string{}, errors.New("the Documents could not be parsed")
+// bodyErr returns the error that stopped the body from being read, such
+// as a size limit, or nil.
+func (r *fetchResult) bodyErr() error {
+	if r.raw == nil {
+		return nil
 	}
+	return r.raw.err
+}
 
-	urls := getLinks(htmlNode)
-	baseURL, err := url.Parse(rawBaseURL)
-
-	if err != nil {
-		//fmt.Printf("error parsing base link %s: %v\n", rawBaseURL, err)
-		baseError = true
+// close closes the response body.
+func (r *fetchResult) close() error {
+	if r.raw == nil {
+		return nil
 	}
+	return r.raw.Close()
+}
 
-	resultURL := []string{}
+// maxRedirects matches the limit of Go's default HTTP client.
+const maxRedirects = 10
 
-	for _, link := range urls {
-		u, err := url.Parse(link)
-		if err != nil {
-			fmt.Printf("error parsing link %s: %v\n", link, err)
-			linkError = true
-		}
+// fetchPage requests rawURL through fetcher and opens the response body
+// for reading, within limits and transcoded to UTF-8. The body is only
+// read as the caller consumes it, and the caller must close the result
+// when the fetch succeeds. When since holds validators from a previous
+// crawl the request is conditional, and a 304 answer comes back as a
+// bodiless result with notModified set.
+func fetchPage(ctx context.Context, rawURL string, limits fetchLimits, since validators, fetcher Fetcher) (*fetchResult, error) {
+	req := &FetchRequest{URL: rawURL, Header: http.Header{}}
 
-		//fmt.Printf("link: %v\n\t\t->Host: %v\n\t\t->Path: %v\n\n", link, u.Host, u.Path)
+	// Asking for gzip ourselves turns off the transport's transparent
+	// decompression, so openBody can see and limit the compressed size.
+	req.Header.Set("Accept-Encoding", "gzip")
+	since.setHeaders(req.Header)
 
-		if u.Host == "" {
-			if baseURL != nil {
-				resultURL = append(resultURL, baseURL.ResolveReference(u).String())
-			}
-		} else {
-			resultURL = append(resultURL, u.String())
-		}
-	}
+	res, err := fetcher.Fetch(ctx, req)
 
-	var errLink error = nil
-	if baseError {
-		errLink = errors.New("the base link was not valid")
-	} else if linkError {
-		errLink = errors.New("the some links were not valid")
+	if err != nil {
+		return nil, err
 	}
 
-	return resultURL, errLink
-}
-
-func getHTML(rawURL string) (string, error) {
-	res, err := http.Get(rawURL)
+	result := &fetchResult{
+		statusCode: res.StatusCode,
+		header:     res.Header,
+		finalURL:   res.FinalURL,
+		redirects:  res.Redirects,
+	}
 
-	if err != nil {
-		return "", err
+	if res.StatusCode == http.StatusNotModified && !since.empty() {
+		res.Body.Close()
+		result.notModified = true
+		return result, nil
 	}
 
 	if res.StatusCode < 200 || res.StatusCode >= 300 {
-		return "", errors.New(res.Status)
+		res.Body.Close()
+		return result, errors.New(res.Status)
 	}
 
 	contentType := res.Header.Get("Content-Type")
 
 	if !strings.Contains(strings.ToLower(contentType), "text/html") {
-		return "", errors.New("invalid content type\n")
+		res.Body.Close()
+		return result, errors.New("invalid content type\n")
 	}
 
-	contentHTML, err := io.ReadAll(res.Body)
-	defer res.Body.Close()
-
+	raw, err := openBody(res, limits)
 	if err != nil {
-		return "", errors.New("error decoding the body\n")
+		res.Body.Close()
+		if errors.Is(err, errBodyTooLarge) {
+			return result, err
+		}
+		return result, errors.New("error decoding the body\n")
 	}
 
-	return string(contentHTML), nil
+	// Working out the charset reads the start of the body; the rest is
+	// left for the caller to stream.
+	body, name, err := newUTF8Reader(raw, contentType)
+	if err != nil {
+		raw.Close()
+		if errors.Is(err, errBodyTooLarge) || errors.Is(err, errDecompressionBomb) {
+			return result, err
+		}
+		return result, errors.New("error decoding the body\n")
+	}
+	result.raw = raw
+	result.body = body
+	result.charset = name
+	return result, nil
 }
 
 func sameDomain(baseURL, otherURL string) bool {
@@ -205,96 +213,285 @@ func sameDomain(baseURL, otherURL string) bool {
 }
 
 func (cfg *config) checkMaxPages() bool {
-	cfg.mu.Lock()
-	defer cfg.mu.Unlock()
-	return len(cfg.pages) >= cfg.maxPages
+	return cfg.pages.len() >= cfg.maxPages
 }
 
-func (cfg *config) crawlPage(rawCurrentURL string) {
-	defer cfg.wg.Done()
-	cfg.concurrencyControl <- struct{}{}
-	defer func() {
-		<-cfg.concurrencyControl
-	}()
+// crawlPage fetches a page taken from the frontier, records what was
+// found and queues the links it contains.
+func (cfg *config) crawlPage(ctx context.Context, item crawlItem, logger *slog.Logger) {
+	rawCurrentURL := item.URL
+	seed := item.Seed
+	normURL := normalizeURL(rawCurrentURL)
 
-	if cfg.checkMaxPages() {
-		return
+	result := &Page{
+		URL:   rawCurrentURL,
+		Seed:  seed,
+		Depth: item.Depth,
+	}
+
+	logger = logger.With("url", rawCurrentURL, "depth", item.Depth)
+	logger.Debug("fetching page")
+	start := time.Now()
+	fetched, err := fetchPage(ctx, rawCurrentURL, cfg.limits, cfg.validatorsFor(normURL), cfg.fetcher)
+	if err == nil {
+		defer fetched.close()
 	}
+	duration := time.Since(start)
 
-	if !sameDomain(cfg.baseURL, rawCurrentURL) {
+	// A fetch cut short by cancellation says nothing about the page; it
+	// is left to be fetched again when the crawl resumes.
+	if ctx.Err() != nil {
+		logger.Debug("fetch cancelled", "duration", duration)
 		return
 	}
 
-	normURL := normalizeURL(rawCurrentURL)
+	var doc *Document
+	defer func() {
+		cfg.storeResult(normURL, result)
+		if err != nil {
+			logger.Warn("fetch failed", "status", result.StatusCode, "duration", duration, "error", err)
+			cfg.hooks.error(result, err)
+		} else {
+			logger.Info("page crawled", "status", result.StatusCode, "duration", duration, "not_modified", result.NotModified)
+			cfg.hooks.page(result, doc)
+		}
+	}()
+
+	if fetched != nil {
+		result.FinalURL = fetched.finalURL
+		result.StatusCode = fetched.statusCode
+		result.ContentType = fetched.header.Get("Content-Type")
+		result.LastModified = parseLastModified(fetched.header.Get("Last-Modified"))
+		result.ETag = fetched.header.Get("ETag")
+		result.Charset = fetched.charset
+		result.Redirects = fetched.redirects
+	}
 
-	if !cfg.addPageVisit(normURL) {
+	if err != nil {
+		result.Err = err.Error()
 		return
 	}
 
-	fmt.Printf("Entering at URL %s\n", rawCurrentURL)
-	htmlText, err := getHTML(rawCurrentURL)
+	if fetched.notModified {
+		result.reuse(cfg.previous[normURL])
+		if !result.NoFollow || cfg.ignoreRobotsMeta {
+			cfg.follow(result, item.Depth+1)
+		}
+		return
+	}
 
-	if err != nil {
-		fmt.Printf("The URL %s not responding: %v\n", normURL, err)
+	// The page is tokenized straight off the response; it is only kept
+	// whole when an OnPage hook wants the document.
+	body := fetched.body
+	var kept *strings.Builder
+	if cfg.hooks.wantDocument() {
+		kept = &strings.Builder{}
+		body = io.TeeReader(body, kept)
+	}
+	extract, extractErr := extractPage(body, result.FinalURL)
+	result.Truncated = fetched.truncated()
+	if err = fetched.bodyErr(); err != nil {
+		result.Err = err.Error()
 		return
 	}
+	if extractErr != nil {
+		logger.Warn("error parsing page", "error", extractErr)
+	}
+	if kept != nil {
+		doc = &Document{URL: result.FinalURL, Body: kept.String()}
+	}
+	allURLs := extract.links
+	result.Links = allURLs
+	result.Canonical = extract.canonical
+	result.Meta = extract.meta
+	result.ContentHash = extract.content
+	result.SimHash = extract.simhash
+
+	robots := extract.robots.merge(parseXRobotsTag(fetched.header))
+	result.NoIndex = robots.noindex
+	result.NoFollow = robots.nofollow
+
+	if result.NoFollow && !cfg.ignoreRobotsMeta {
+		return
+	}
+
+	cfg.follow(result, item.Depth+1)
+}
 
-	allURLs, err := getURLsFromHTML(htmlText, cfg.baseURL)
+// follow queues the links of page that the OnLink hooks let through.
+func (cfg *config) follow(page *Page, depth int) {
+	for _, link := range page.Links {
+		if cfg.hooks.link(page, link) {
+			cfg.enqueue(link, depth)
+		}
+	}
+}
 
-	if len(allURLs) == 0 {
-		fmt.Printf("%v", err)
+func (cfg *config) storeResult(normalizedURL string, result *Page) {
+	cfg.resultMu.Lock()
+	defer cfg.resultMu.Unlock()
+	if err := cfg.results.put(normalizedURL, result); err != nil {
+		cfg.logger.Error("error storing page", "url", result.URL, "error", err)
 		return
 	}
+	cfg.counts.count(result)
+	cfg.saved.track(normalizedURL)
+}
 
-	for _, link := range allURLs {
-		cfg.wg.Add(1)
-		go cfg.crawlPage(link)
+// result returns the stored page of normalizedURL, if there is one.
+func (cfg *config) result(normalizedURL string) (*Page, bool) {
+	page, ok, err := cfg.results.get(normalizedURL)
+	if err != nil {
+		cfg.logger.Error("error reading page", "url", normalizedURL, "error", err)
 	}
+	return page, ok
 }
 
 func (cfg *config) addPageVisit(normalizedURL string) bool {
-	cfg.mu.Lock()
-	defer cfg.mu.Unlock()
-	_, ok := cfg.pages[normalizedURL]
-	if ok {
-		cfg.pages[normalizedURL]++
+	first, err := cfg.pages.visit(normalizedURL)
+	if err != nil {
+		cfg.logger.Error("error recording visit", "url", normalizedURL, "error", err)
 		return false
-	} else {
-		cfg.pages[normalizedURL] = 1
+	}
+	return first
+}
+
+// pageCounts returns the number of internal links found to every page.
+// Seen-sets that keep no counts have them rebuilt from the stored pages.
+func (cfg *config) pageCounts() map[string]int {
+	cfg.resultMu.Lock()
+	defer cfg.resultMu.Unlock()
+	counts := make(map[string]int, cfg.pages.len())
+	err := cfg.pages.each(func(key string, count int) bool {
+		counts[key] = count
 		return true
+	})
+	if errors.Is(err, errCountsUnavailable) {
+		return cfg.countLinksLocked()
 	}
+	if err != nil {
+		cfg.logger.Error("error reading page counts", "error", err)
+	}
+	return counts
 }
 
-func (cfg *config) printReport() {
+// countLinksLocked counts, for every crawled page, the seeds naming it
+// and the followed links to it from other crawled pages.
+func (cfg *config) countLinksLocked() map[string]int {
+	counts := make(map[string]int, cfg.results.len())
+	err := cfg.results.each(func(key string, _ *Page) bool {
+		counts[key] = 0
+		return true
+	})
+	for _, seed := range cfg.seeds {
+		if _, ok := counts[normalizeURL(seed)]; ok {
+			counts[normalizeURL(seed)]++
+		}
+	}
+	if err == nil {
+		err = cfg.results.each(func(_ string, result *Page) bool {
+			if result.NoFollow && !cfg.ignoreRobotsMeta {
+				return true
+			}
+			for _, link := range result.Links {
+				if _, ok := cfg.seedFor(link); !ok {
+					continue
+				}
+				if _, ok := counts[normalizeURL(link)]; ok {
+					counts[normalizeURL(link)]++
+				}
+			}
+			return true
+		})
+	}
+	if err != nil {
+		cfg.logger.Error("error reading pages", "error", err)
+	}
+	return counts
+}
+
+// writeReport writes the per-seed link report to w.
+func (cfg *config) writeReport(w io.Writer) {
 	type LinkCount struct {
 		count int
 		link  string
 	}
 
-	linkList := []LinkCount{}
+	linksBySeed := map[string][]LinkCount{}
 
-	for link, val := range cfg.pages {
-		linkList = append(linkList, LinkCount{count: val, link: link})
+	for link, val := range cfg.pageCounts() {
+		seed := ""
+		if result, ok := cfg.result(link); ok {
+			seed = result.Seed
+		}
+		linksBySeed[seed] = append(linksBySeed[seed], LinkCount{count: val, link: link})
 	}
-	sort.SliceStable(
-		linkList,
-		func(i, j int) bool {
-			return linkList[i].link < linkList[j].link
-		},
-	)
 
-	sort.SliceStable(
-		linkList,
-		func(i, j int) bool {
-			return linkList[i].count > linkList[j].count
-		},
-	)
+	for _, seed := range cfg.seeds {
+		linkList := linksBySeed[seed]
+
+		sort.SliceStable(
+			linkList,
+			func(i, j int) bool {
+				return linkList[i].link < linkList[j].link
+			},
+		)
+
+		sort.SliceStable(
+			linkList,
+			func(i, j int) bool {
+				return linkList[i].count > linkList[j].count
+			},
+		)
+
+		fmt.Fprintf(w, "\n\n\n=============================\n")
+		fmt.Fprintf(w, "REPORT for %s\n", seed)
+		fmt.Fprintf(w, "=============================\n\n\n")
+
+		noindex := []string{}
+		nofollow := []string{}
+		for _, val := range linkList {
+			fmt.Fprintf(w, "Found %d internal links to %s\n", val.count, val.link)
+			if result, ok := cfg.result(val.link); ok {
+				if result.NoIndex {
+					noindex = append(noindex, val.link)
+				}
+				if result.NoFollow {
+					nofollow = append(nofollow, val.link)
+				}
+			}
+		}
 
-	fmt.Printf("\n\n\n=============================\n")
-	fmt.Printf("REPORT for %s\n", cfg.baseURL)
-	fmt.Printf("=============================\n\n\n")
+		if len(noindex) > 0 {
+			fmt.Fprintf(w, "\nPages marked noindex:\n")
+			for _, link := range noindex {
+				fmt.Fprintf(w, "  %s\n", link)
+			}
+		}
+		if len(nofollow) > 0 {
+			if cfg.ignoreRobotsMeta {
+				fmt.Fprintf(w, "\nPages marked nofollow (followed anyway):\n")
+			} else {
+				fmt.Fprintf(w, "\nPages marked nofollow (links not followed):\n")
+			}
+			for _, link := range nofollow {
+				fmt.Fprintf(w, "  %s\n", link)
+			}
+		}
+	}
+
+	cfg.writeTraps(w)
+
+	if cfg.previous != nil {
+		counts := cfg.recrawlCounts()
+		fmt.Fprintf(w, "\nIncremental recrawl: %d unchanged, %d modified, %d new\n", counts.unchanged, counts.modified, counts.new)
+	}
+
+	if bloom, ok := cfg.pages.(*bloomSeenSet); ok {
+		fmt.Fprintf(w, "\nSeen-set: %s\n", bloom)
+	}
 
-	for _, val := range linkList {
-		fmt.Printf("Found %d internal links to %s\n", val.count, val.link)
+	if cfg.metrics != nil {
+		fmt.Fprintf(w, "\nFetches: %s\n", cfg.metrics)
 	}
 }
@@ -1,15 +1,18 @@
-package main // Use the actual package name where normalizeURL resides
+package crawler // Use the actual package name where normalizeURL resides
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"reflect"
-	"sort"
 	"strings"
-	"sync"
 	"testing"
+
+	"golang.org/x/net/html"
 )
 
 // TestNormalizeURL tests the normalizeURL function with various inputs.
@@ -166,343 +169,87 @@ func TestNormalizeURL(t *testing.T) {
 	}
 }
 
-func TestGetURLsFromHTML(t *testing.T) {
-	testCases := []struct {
-		name         string   // Nombre del caso de prueba
-		htmlBody     string   // Cuerpo HTML de entrada
-		baseURL      string   // URL base para resolver URLs relativas
-		expectedURLs []string // URLs absolutas esperadas (ordenadas)
-		expectError  bool     // Si se espera un error
-	}{
-		{
-			name:         "Sin enlaces",
-			htmlBody:     `<html><body><p>Sin enlaces aquí.</p></body></html>`,
-			baseURL:      "https://example.com",
-			expectedURLs: []string{},
-			expectError:  false,
-		},
-		{
-			name:         "Enlace absoluto simple",
-			htmlBody:     `<html><body><a href="https://blog.boot.dev">Boot.dev Blog</a></body></html>`,
-			baseURL:      "https://example.com",
-			expectedURLs: []string{"https://blog.boot.dev"},
-			expectError:  false,
-		},
-		{
-			name:         "Enlace relativo simple",
-			htmlBody:     `<html><body><a href="/path/to/page">Página Relativa</a></body></html>`,
-			baseURL:      "https://example.com",
-			expectedURLs: []string{"https://example.com/path/to/page"},
-			expectError:  false,
-		},
-		{
-			name: "Múltiples enlaces (absolutos y relativos)",
-			htmlBody: `
-				<html>
-					<body>
-						<a href="https://other.com/page1">Absoluto</a>
-						<p>Algo de texto</p>
-						<a href="/relative/page2">Relativo</a>
-						<a href="https://example.com/another/path">Otro Absoluto</a>
-						<a href="justafile.html">Archivo Relativo</a>
-					</body>
-				</html>`,
-			baseURL: "https://example.com/base/", // Base URL con path
-			expectedURLs: []string{
-				"https://example.com/another/path",
-				"https://example.com/base/justafile.html", // Relativo a /base/
-				"https://example.com/relative/page2",      // Relativo a la raíz del host
-				"https://other.com/page1",
-			},
-			expectError: false,
-		},
-		{
-			name:         "Enlace relativo sin slash inicial",
-			htmlBody:     `<html><body><a href="subfolder/page">Subcarpeta</a></body></html>`,
-			baseURL:      "https://example.com/current/path/",
-			expectedURLs: []string{"https://example.com/current/path/subfolder/page"},
-			expectError:  false,
-		},
-		{
-			name:         "Enlace relativo con ..",
-			htmlBody:     `<html><body><a href="../otherfolder/page">Otra Carpeta</a></body></html>`,
-			baseURL:      "https://example.com/current/path/",
-			expectedURLs: []string{"https://example.com/current/otherfolder/page"},
-			expectError:  false,
-		},
-		{
-			name:         "Enlace a la raíz",
-			htmlBody:     `<html><body><a href="/">Inicio</a></body></html>`,
-			baseURL:      "https://example.com/current/path/",
-			expectedURLs: []string{"https://example.com/"},
-			expectError:  false,
-		},
-		{
-			name:         "URL base inválida",
-			htmlBody:     `<html><body><a href="/path">Relativo</a></body></html>`,
-			baseURL:      ":invalid-url:",
-			expectedURLs: []string{},
-			expectError:  true,
-		},
-		{
-			name:         "HTML vacío",
-			htmlBody:     "",
-			baseURL:      "https://example.com",
-			expectedURLs: []string{},
-			expectError:  false,
-		},
-		{
-			name: "Ignorar fragmentos y queries (si la normalización no es parte de getURLs)",
-			htmlBody: `<html><body>
-						<a href="/path?query=1">Con Query</a>
-						<a href="/path#fragment">Con Fragmento</a>
-					   </body></html>`,
-			baseURL: "https://example.com",
-			// Asumiendo que getURLsFromHTML solo extrae y resuelve, sin normalizar quitando query/fragment
-			expectedURLs: []string{
-				"https://example.com/path#fragment",
-				"https://example.com/path?query=1",
-			},
-			expectError: false,
-		},
-		{
-			name:     "URL base con path sin slash final",
-			htmlBody: `<html><body><a href="page.html">Página</a></body></html>`,
-			baseURL:  "https://example.com/folder", // Sin slash final
-			// El comportamiento estándar de url.Parse es resolver relativo al 'directorio' padre
-			expectedURLs: []string{"https://example.com/page.html"},
-			expectError:  false,
-		},
-		{
-			name:         "URL base con path con slash final",
-			htmlBody:     `<html><body><a href="page.html">Página</a></body></html>`,
-			baseURL:      "https://example.com/folder/", // Con slash final
-			expectedURLs: []string{"https://example.com/folder/page.html"},
-			expectError:  false,
-		},
-
-		// Añadir más casos de prueba según sea necesario
-	}
-
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			// Llama a la función bajo prueba (Asegúrate de que esté disponible en el paquete)
-			actualURLs, err := getURLsFromHTML(tc.htmlBody, tc.baseURL)
-
-			// Verifica si se esperaba un error y si ocurrió (o no)
-			if tc.expectError {
-				if err == nil {
-					t.Errorf("Se esperaba un error, pero no ocurrió ninguno.")
-				}
-				// Puedes añadir verificaciones más específicas sobre el tipo de error si es necesario
-				return // No continuar si se esperaba un error
-			}
-			if err != nil {
-				t.Errorf("No se esperaba un error, pero ocurrió: %v", err)
-				return // No continuar si ocurrió un error inesperado
-			}
-
-			// Ordena ambos slices (actual y esperado) para una comparación consistente
-			sort.Strings(actualURLs)
-			sort.Strings(tc.expectedURLs)
-
-			// Compara los resultados
-			if !reflect.DeepEqual(actualURLs, tc.expectedURLs) {
-				t.Errorf("getURLsFromHTML(%q, %q)\n  got: %v\n want: %v", tc.htmlBody, tc.baseURL, actualURLs, tc.expectedURLs)
-			}
-		})
-	}
-}
-
 // Mock Handler Helper
 func createMockServer(handler http.HandlerFunc) *httptest.Server {
 	return httptest.NewServer(handler)
 }
 
-// --- Test Cases ---
-
-func TestGetHTML_HappyPath(t *testing.T) {
-	expectedHTML := "<html><body><h1>Hello</h1></body></html>"
-	server := createMockServer(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/html")
-		fmt.Fprintln(w, expectedHTML)
-	})
-	defer server.Close()
-
-	html, err := getHTML(server.URL)
-
-	if err != nil {
-		t.Fatalf("Expected no error, but got: %v", err)
-	}
-	// Trim potential trailing newline added by Fprintln
-	if strings.TrimSpace(html) != expectedHTML {
-		t.Errorf("Expected HTML '%s', but got '%s'", expectedHTML, html)
-	}
-}
-
-func TestGetHTML_HappyPathWithCharset(t *testing.T) {
-	expectedHTML := "<html><body><h1>Charset</h1></body></html>"
-	server := createMockServer(func(w http.ResponseWriter, r *http.Request) {
-		// Common variation
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		fmt.Fprintln(w, expectedHTML)
-	})
-	defer server.Close()
-
-	html, err := getHTML(server.URL)
-
+// readFetched reads and closes the body of a successful fetch.
+func readFetched(t *testing.T, res *fetchResult) string {
+	t.Helper()
+	defer res.close()
+	body, err := io.ReadAll(res.body)
 	if err != nil {
-		t.Fatalf("Expected no error, but got: %v", err)
-	}
-	if strings.TrimSpace(html) != expectedHTML {
-		t.Errorf("Expected HTML '%s', but got '%s'", expectedHTML, html)
+		t.Fatalf("error reading the body: %v", err)
 	}
+	return string(body)
 }
 
-func TestGetHTML_HappyPathCaseInsensitive(t *testing.T) {
-	expectedHTML := "<html><body><h1>Case Test</h1></body></html>"
-	server := createMockServer(func(w http.ResponseWriter, r *http.Request) {
-		// Case variation
-		w.Header().Set("Content-Type", "TEXT/HTML")
-		fmt.Fprintln(w, expectedHTML)
-	})
-	defer server.Close()
-
-	html, err := getHTML(server.URL)
-
-	if err != nil {
-		t.Fatalf("Expected no error for case-insensitive header, but got: %v", err)
-	}
-	if strings.TrimSpace(html) != expectedHTML {
-		t.Errorf("Expected HTML '%s', but got '%s'", expectedHTML, html)
-	}
-}
-
-func TestGetHTML_ErrorInvalidContentType_JSON(t *testing.T) {
-	server := createMockServer(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintln(w, `{"key": "value"}`)
-	})
-	defer server.Close()
-
-	html, err := getHTML(server.URL)
-
-	if err == nil {
-		t.Fatalf("Expected an error for content type application/json, but got nil")
-	}
-	// Assuming the error message indicates the issue
-	if !strings.Contains(strings.ToLower(err.Error()), "invalid content type") && !strings.Contains(strings.ToLower(err.Error()), "content-type") {
-		t.Errorf("Expected error message to contain 'invalid content type', but got: %v", err)
-	}
-	if html != "" {
-		t.Errorf("Expected empty HTML string on error, but got: %s", html)
-	}
-}
-
-func TestGetHTML_ErrorInvalidContentType_PlainText(t *testing.T) {
-	server := createMockServer(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/plain")
-		fmt.Fprintln(w, "This is plain text.")
-	})
-	defer server.Close()
-
-	html, err := getHTML(server.URL)
-
-	if err == nil {
-		t.Fatalf("Expected an error for content type text/plain, but got nil")
-	}
-	if !strings.Contains(strings.ToLower(err.Error()), "invalid content type") && !strings.Contains(strings.ToLower(err.Error()), "content-type") {
-		t.Errorf("Expected error message to contain 'invalid content type', but got: %v", err)
-	}
-	if html != "" {
-		t.Errorf("Expected empty HTML string on error, but got: %s", html)
-	}
-}
+// --- Test Cases ---
 
-func TestGetHTML_ErrorInvalidURL(t *testing.T) {
-	invalidURLs := []string{
-		"",                                   // Empty
-		"htp://google.com",                   // Invalid scheme
-		"just string",                        // Not a URL
-		"://google.com",                      // Missing scheme
-		"http://invalid url with spaces.com", // Spaces (needs encoding)
+func TestFetchPage(t *testing.T) {
+	page := "<html><body><h1>Hello</h1></body></html>"
+	testCases := []struct {
+		name        string
+		contentType string
+		status      int
+		wantErr     string // part of the error, empty for success
+	}{
+		{name: "HTML", contentType: "text/html"},
+		{name: "HTML with charset", contentType: "text/html; charset=utf-8"},
+		{name: "Case insensitive content type", contentType: "TEXT/HTML"},
+		{name: "JSON", contentType: "application/json", wantErr: "invalid content type"},
+		{name: "Plain text", contentType: "text/plain", wantErr: "invalid content type"},
+		{name: "Server error", contentType: "text/html", status: http.StatusInternalServerError, wantErr: "500"},
+		{name: "Not found", contentType: "text/html", status: http.StatusNotFound, wantErr: "404"},
 	}
 
-	for _, url := range invalidURLs {
-		t.Run(fmt.Sprintf("URL_%s", url), func(t *testing.T) {
-			html, err := getHTML(url)
-			if err == nil {
-				t.Errorf("Expected an error for invalid URL '%s', but got nil", url)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := createMockServer(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tc.contentType)
+				if tc.status != 0 {
+					w.WriteHeader(tc.status)
+				}
+				fmt.Fprintln(w, page)
+			})
+			defer server.Close()
+
+			res, err := fetchPage(context.Background(), server.URL, defaultFetchLimits, validators{}, &HTTPFetcher{})
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tc.wantErr, err)
+				}
+				if res == nil || res.body != nil {
+					t.Errorf("expected a result without a body, got %+v", res)
+				}
+				return
 			}
-			// The specific error might vary (e.g., url.Parse error)
-			// We just check that *an* error occurred.
-			if html != "" {
-				t.Errorf("Expected empty HTML string on error, but got: %s", html)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if body := readFetched(t, res); strings.TrimSpace(body) != page {
+				t.Errorf("body = %q; want %q", body, page)
 			}
 		})
 	}
 }
 
-func TestGetHTML_ErrorServerError(t *testing.T) {
-	server := createMockServer(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusInternalServerError) // 500
-		fmt.Fprintln(w, "Internal Server Error")
-	})
-	defer server.Close()
-
-	html, err := getHTML(server.URL)
-
-	if err == nil {
-		t.Fatalf("Expected an error for 500 status code, but got nil")
-	}
-	// Check if error indicates non-2xx status
-	if !strings.Contains(err.Error(), "status code") && !strings.Contains(err.Error(), "500") {
-		t.Errorf("Expected error message to mention non-2xx status or 500, but got: %v", err)
-	}
-	if html != "" {
-		t.Errorf("Expected empty HTML string on error, but got: %s", html)
-	}
-}
-
-func TestGetHTML_ErrorNotFound(t *testing.T) {
-	server := createMockServer(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusNotFound) // 404
-		fmt.Fprintln(w, "Not Found")
-	})
-	defer server.Close()
-
-	html, err := getHTML(server.URL)
-
-	if err == nil {
-		t.Fatalf("Expected an error for 404 status code, but got nil")
-	}
-	if !strings.Contains(err.Error(), "status code") && !strings.Contains(err.Error(), "404") {
-		t.Errorf("Expected error message to mention non-2xx status or 404, but got: %v", err)
+func TestFetchPage_Unreachable(t *testing.T) {
+	urls := []string{
+		"",
+		"htp://google.com",
+		"://google.com",
+		"http://invalid url with spaces.com",
+		"http://127.0.0.1:9999/unreachable",
 	}
-	if html != "" {
-		t.Errorf("Expected empty HTML string on error, but got: %s", html)
-	}
-}
-
-// Note: Testing actual network errors like DNS resolution failure or connection
-// refused is harder with httptest. These often require integration tests or
-// more complex mocking of the network stack itself. A simple proxy might be
-// trying a URL that's syntactically valid but guaranteed not to resolve or connect.
-func TestGetHTML_ErrorNetwork(t *testing.T) {
-	// This URL is syntactically valid but unlikely to be served, simulating a network issue.
-	// Use with caution, as environment might affect this.
-	unreachableURL := "http://127.0.0.1:9999/unreachable"
-	// Or a non-resolvable domain: "http://domain.invalid/"
 
-	html, err := getHTML(unreachableURL)
-
-	if err == nil {
-		t.Fatalf("Expected a network error for unreachable URL '%s', but got nil", unreachableURL)
-	}
-	// Network errors can vary (connection refused, context deadline exceeded, no such host)
-	// We just expect *some* error.
-	if html != "" {
-		t.Errorf("Expected empty HTML string on network error, but got: %s", html)
+	for _, rawURL := range urls {
+		t.Run(rawURL, func(t *testing.T) {
+			if _, err := fetchPage(context.Background(), rawURL, defaultFetchLimits, validators{}, &HTTPFetcher{}); err == nil {
+				t.Errorf("expected an error for %q", rawURL)
+			}
+		})
 	}
 }
 
@@ -761,10 +508,10 @@ func TestCrawlPage_InternalLinksOnly_SchemeLessNormalized(t *testing.T) {
 	expectedHostPort := baseURL.Host    // e.g., 127.0.0.1:xxxx
 
 	// Define expected keys based on the assumed scheme-less normalization
-	expectedInternalKeys[expectedHostPort] = true          // Root page (no path)
-	expectedInternalKeys[expectedHostPort+"/pagea"] = true // Page A
-	expectedInternalKeys[expectedHostPort+"/pageb"] = true // Page B
-	expectedInternalKeys[expectedHostPort+"/pagec"] = true // Page C (link found, even if page empty/404)
+	expectedInternalKeys[expectedHostPort] = true                // Root page (no path)
+	expectedInternalKeys[expectedHostPort+"/pagea"] = true       // Page A
+	expectedInternalKeys[expectedHostPort+"/pageb"] = true       // Page B
+	expectedInternalKeys[expectedHostPort+"/pageb/pagec"] = true // Page C, relative to /pageB/ (link found, even if page empty/404)
 
 	// --- Define Page Content ---
 	// Links should still be standard URLs; crawlPage/normalizeURL handle conversion
@@ -784,30 +531,20 @@ func TestCrawlPage_InternalLinksOnly_SchemeLessNormalized(t *testing.T) {
 	})
 	pageContents["/pageB"] = createHTML("Page B", []string{
 		"http://another-external.org", // External (ignore)
-		"pageC",                       // -> host:port/pagec
+		"pageC",                       // -> host:port/pageb/pagec, as page B is first reached as /pageB/
 	})
 	// pageC is not defined, simulating empty/404
 
 	// --- Execute the Crawl ---
-	c := config{
-		pages:              make(map[string]int),
-		baseURL:            server.URL,
-		maxConcurrency:     1,
-		maxPages:           100,
-		mu:                 &sync.Mutex{},
-		concurrencyControl: make(chan struct{}, 1),
-		wg:                 &sync.WaitGroup{},
-	}
-	c.wg.Add(1)
-	go c.crawlPage(server.URL) // Start crawl from the base URL
-	c.wg.Wait()
+	c := newConfig([]string{server.URL}, 1, 100)
+	c.crawl(context.Background()) // Start crawl from the base URL
 
 	// --- Assertions ---
 	foundInternalKeys := make(map[string]bool)
 	foundMalformedKeys := make(map[string]bool) // Keys that don't seem to match expected format
 
 	t.Logf("--- Pages Map Contents (Expected Host:Port: %s) ---", expectedHostPort)
-	for k, v := range c.pages {
+	for k, v := range c.pageCounts() {
 		t.Logf("Key: %s, Count: %d", k, v)
 		// Check if the key starts with the expected host:port to categorize
 		// This assumes external URLs are correctly excluded *before* storing.
@@ -847,3 +584,266 @@ func TestCrawlPage_InternalLinksOnly_SchemeLessNormalized(t *testing.T) {
 		t.Errorf("Test failed because malformed or potentially external keys were found in the pages map (see previous errors).")
 	}
 }
+
+func TestExtractPage(t *testing.T) {
+	testCases := []struct {
+		name          string
+		htmlBody      string
+		pageURL       string
+		wantLinks     []string
+		wantCanonical string
+		wantNoIndex   bool
+		wantErr       bool
+	}{
+		{
+			name:      "Empty body",
+			htmlBody:  "",
+			pageURL:   "https://example.com",
+			wantLinks: []string{},
+		},
+		{
+			name:      "No signals",
+			htmlBody:  `<html><head><title>x</title></head><body><a href="/b">b</a><a name="top">no href</a></body></html>`,
+			pageURL:   "https://example.com/a",
+			wantLinks: []string{"https://example.com/b"},
+		},
+		{
+			name:      "Relative links on a deep page",
+			htmlBody:  `<html><body><a href="b.html">b</a><a href="../c">c</a></body></html>`,
+			pageURL:   "https://example.com/a/x/",
+			wantLinks: []string{"https://example.com/a/x/b.html", "https://example.com/a/c"},
+		},
+		{
+			name:      "Page path without a trailing slash",
+			htmlBody:  `<html><body><a href="page.html">page</a></body></html>`,
+			pageURL:   "https://example.com/folder",
+			wantLinks: []string{"https://example.com/page.html"},
+		},
+		{
+			name:      "Page path with a trailing slash",
+			htmlBody:  `<html><body><a href="page.html">page</a></body></html>`,
+			pageURL:   "https://example.com/folder/",
+			wantLinks: []string{"https://example.com/folder/page.html"},
+		},
+		{
+			name:      "Query and fragment are kept",
+			htmlBody:  `<html><body><a href="/path?query=1">q</a><a href="/path#fragment">f</a></body></html>`,
+			pageURL:   "https://example.com",
+			wantLinks: []string{"https://example.com/path?query=1", "https://example.com/path#fragment"},
+		},
+		{
+			name:      "Invalid page URL",
+			htmlBody:  `<html><body><a href="/path">relative</a><a href="https://other.com/">absolute</a></body></html>`,
+			pageURL:   ":invalid-url:",
+			wantLinks: []string{"https://other.com/"},
+			wantErr:   true,
+		},
+		{
+			name:          "Base href",
+			htmlBody:      `<html><head><base href="/docs/"><base href="/ignored/"><link rel="canonical" href="here"></head><body><a href="b.html">b</a></body></html>`,
+			pageURL:       "https://example.com/a/x/",
+			wantLinks:     []string{"https://example.com/docs/b.html"},
+			wantCanonical: "https://example.com/a/x/here",
+		},
+		{
+			name:          "Relative canonical",
+			htmlBody:      `<html><head><link rel="canonical" href="/b"></head></html>`,
+			pageURL:       "https://example.com/a",
+			wantLinks:     []string{},
+			wantCanonical: "https://example.com/b",
+		},
+		{
+			name:        "Robots noindex",
+			htmlBody:    `<html><head><meta name="ROBOTS" content="NoIndex, follow"></head></html>`,
+			pageURL:     "https://example.com/a",
+			wantLinks:   []string{},
+			wantNoIndex: true,
+		},
+		{
+			name:      "Uppercase tags, entities and self-closing",
+			htmlBody:  `<HTML><BODY><A HREF="/x?a=1&amp;b=2">x</A><a href="https://other.com/"/></BODY></HTML>`,
+			pageURL:   "https://example.com/",
+			wantLinks: []string{"https://example.com/x?a=1&b=2", "https://other.com/"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			extract, err := extractPage(strings.NewReader(tc.htmlBody), tc.pageURL)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("error = %v; want an error: %v", err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(extract.links, tc.wantLinks) {
+				t.Errorf("links\n  got: %v\n want: %v", extract.links, tc.wantLinks)
+			}
+			if extract.canonical != tc.wantCanonical || extract.robots.noindex != tc.wantNoIndex {
+				t.Errorf("signals = (%q, %v); want (%q, %v)", extract.canonical, extract.robots.noindex, tc.wantCanonical, tc.wantNoIndex)
+			}
+		})
+	}
+}
+
+func TestExtractPage_Metadata(t *testing.T) {
+	body := `<!DOCTYPE html>
+<html lang="es-MX">
+<head>
+	<title>  Página   de
+		prueba </title>
+	<meta name="description" content="Una descripción   corta">
+	<meta name="robots" content="noindex, nofollow">
+	<meta property="og:title" content="OG title">
+	<meta property="og:image" content="https://example.com/a.png">
+	<meta name="twitter:card" content="summary">
+	<style>body { color: red; }</style>
+	<script>var ignored = "these words do not count";</script>
+</head>
+<body>
+	<h1>Main <em>heading</em></h1>
+	<p>One two three four five.</p>
+	<h2>First sub</h2>
+	<h2>Second sub</h2>
+	<h3>Deep</h3>
+	<svg><title>not the page title</title></svg>
+	<noscript>enable javascript please</noscript>
+</body>
+</html>`
+
+	extract, err := extractPage(strings.NewReader(body), "https://example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := PageMetadata{
+		Title:       "Página de prueba",
+		Description: "Una descripción corta",
+		Robots:      "noindex, nofollow",
+		H1:          []string{"Main heading"},
+		H1Count:     1,
+		H2:          []string{"First sub", "Second sub"},
+		H3:          []string{"Deep"},
+		Lang:        "es-MX",
+		OpenGraph:   map[string]string{"og:title": "OG title", "og:image": "https://example.com/a.png"},
+		Twitter:     map[string]string{"twitter:card": "summary"},
+		// Main heading + five words + First sub + Second sub + Deep.
+		WordCount: 2 + 5 + 2 + 2 + 1,
+	}
+	if !reflect.DeepEqual(extract.meta, want) {
+		t.Errorf("metadata\n  got: %+v\n want: %+v", extract.meta, want)
+	}
+	if !extract.robots.noindex || !extract.robots.nofollow {
+		t.Errorf("expected noindex and nofollow to be set from the robots meta tag")
+	}
+}
+
+// getURLsFromHTML is the DOM based link extractor extractPage replaced.
+// It is kept as a reference for extractPage's links and speed.
+func getURLsFromHTML(htmlBody, rawBaseURL string) ([]string, error) {
+	doc, err := html.Parse(strings.NewReader(htmlBody))
+	if err != nil {
+		return []string{}, errors.New("the Documents could not be parsed")
+	}
+	baseURL, baseErr := url.Parse(rawBaseURL)
+
+	links := []string{}
+	linkErr := false
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.ElementNode && node.Data == "a" {
+			for _, attr := range node.Attr {
+				if attr.Key != "href" {
+					continue
+				}
+				u, err := url.Parse(attr.Val)
+				if err != nil {
+					linkErr = true
+					continue
+				}
+				if u.Host != "" {
+					links = append(links, u.String())
+				} else if baseErr == nil {
+					links = append(links, baseURL.ResolveReference(u).String())
+				}
+			}
+		}
+		for child := range node.ChildNodes() {
+			walk(child)
+		}
+	}
+	walk(doc)
+
+	if baseErr != nil {
+		return links, errors.New("the base link was not valid")
+	}
+	if linkErr {
+		return links, errors.New("the some links were not valid")
+	}
+	return links, nil
+}
+
+// extractPage must find the same links as the DOM based getURLsFromHTML.
+func TestExtractPage_MatchesGetURLsFromHTML(t *testing.T) {
+	bodies := []string{
+		"",
+		`<html><body><p>Sin enlaces aquí.</p></body></html>`,
+		`<html><body><a href="https://other.com/page1">A</a><div><p><a href="/relative/page2">R</a></p></div><a href="justafile.html">F</a></body></html>`,
+		`<a href="../otherfolder/page">unclosed <b><a href="#frag">nested</a>`,
+		benchmarkPage(50, 5),
+	}
+
+	for _, base := range []string{"https://example.com/base/", ":invalid-url:"} {
+		for i, body := range bodies {
+			want, errWant := getURLsFromHTML(body, base)
+			extract, err := extractPage(strings.NewReader(body), base)
+			if (err == nil) != (errWant == nil) {
+				t.Errorf("%s body %d: error mismatch: extractPage %v, getURLsFromHTML %v", base, i, err, errWant)
+			}
+			if !reflect.DeepEqual(extract.links, want) {
+				t.Errorf("%s body %d: links differ\n  extractPage: %v\n getURLsFromHTML: %v", base, i, extract.links, want)
+			}
+		}
+	}
+}
+
+// benchmarkPage builds a page with the given number of links, each wrapped
+// in depth nested elements.
+func benchmarkPage(links, depth int) string {
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html><html><head><title>Bench</title></head><body>")
+	for i := range links {
+		b.WriteString(strings.Repeat("<div><span>", depth))
+		fmt.Fprintf(&b, `<p>Some filler text before link %d.</p><a href="/page/%d?ref=bench">Link %d</a>`, i, i, i)
+		b.WriteString(strings.Repeat("</span></div>", depth))
+	}
+	b.WriteString("</body></html>")
+	return b.String()
+}
+
+func BenchmarkGetURLsFromHTML(b *testing.B) {
+	for _, size := range []int{100, 1000, 10000} {
+		body := benchmarkPage(size, 10)
+		b.Run(fmt.Sprintf("links=%d", size), func(b *testing.B) {
+			b.SetBytes(int64(len(body)))
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := getURLsFromHTML(body, "https://example.com"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkExtractPage(b *testing.B) {
+	for _, size := range []int{100, 1000, 10000} {
+		body := benchmarkPage(size, 10)
+		b.Run(fmt.Sprintf("links=%d", size), func(b *testing.B) {
+			b.SetBytes(int64(len(body)))
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := extractPage(strings.NewReader(body), "https://example.com"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
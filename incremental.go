@@ -0,0 +1,170 @@
+package crawler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// validators are the cache validators a previous crawl stored for a page,
+// sent back as If-None-Match and If-Modified-Since.
+type validators struct {
+	etag         string
+	lastModified time.Time
+}
+
+func (v validators) empty() bool {
+	return v.etag == "" && v.lastModified.IsZero()
+}
+
+// setHeaders makes a request with header conditional.
+func (v validators) setHeaders(header http.Header) {
+	if v.etag != "" {
+		header.Set("If-None-Match", v.etag)
+	}
+	if !v.lastModified.IsZero() {
+		header.Set("If-Modified-Since", v.lastModified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// validatorsFor returns the validators stored for a page in the previous
+// crawl, if any.
+func (cfg *config) validatorsFor(normalizedURL string) validators {
+	prev, ok := cfg.previous[normalizedURL]
+	if !ok {
+		return validators{}
+	}
+	return validators{etag: prev.ETag, lastModified: prev.LastModified}
+}
+
+// reuse fills an unchanged page in from what the previous crawl stored
+// about it, so its links are followed without downloading it again.
+func (p *Page) reuse(prev *Page) {
+	p.StatusCode = prev.StatusCode
+	p.ContentType = prev.ContentType
+	p.Charset = prev.Charset
+	p.Canonical = prev.Canonical
+	p.NoIndex = prev.NoIndex
+	p.NoFollow = prev.NoFollow
+	p.Links = prev.Links
+	p.Meta = prev.Meta
+	p.ContentHash = prev.ContentHash
+	p.SimHash = prev.SimHash
+	if p.ETag == "" {
+		p.ETag = prev.ETag
+	}
+	if p.LastModified.IsZero() {
+		p.LastModified = prev.LastModified
+	}
+	p.NotModified = true
+}
+
+// loadPreviousCrawl reads the pages a crawl stored with -sqlite fetched
+// successfully, keyed by normalized URL. Only those with an ETag or a
+// Last-Modified date can be revalidated; the rest are downloaded again.
+func loadPreviousCrawl(path string) (map[string]*Page, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var version string
+	if err := db.QueryRow(`SELECT value FROM crawl_info WHERE key = 'schema_version'`).Scan(&version); err != nil {
+		return nil, fmt.Errorf("error reading schema version of %s: %w", path, err)
+	}
+	if version != sqliteSchemaVersion {
+		return nil, fmt.Errorf("unsupported schema version %s in %s", version, path)
+	}
+
+	rows, err := db.Query(`SELECT url, requested_url, coalesce(final_url, ''), status_code,
+		coalesce(content_type, ''), coalesce(charset, ''), coalesce(last_modified, ''), coalesce(etag, ''),
+		coalesce(canonical, ''), noindex, nofollow, coalesce(content_hash, ''), coalesce(simhash, ''), meta
+		FROM pages
+		WHERE status_code BETWEEN 200 AND 299
+		AND url NOT IN (SELECT url FROM errors)`)
+	if err != nil {
+		return nil, fmt.Errorf("error reading pages from %s: %w", path, err)
+	}
+	defer rows.Close()
+
+	pages := map[string]*Page{}
+	for rows.Next() {
+		var key, lastModified, simhash, meta string
+		page := &Page{}
+		err := rows.Scan(&key, &page.URL, &page.FinalURL, &page.StatusCode,
+			&page.ContentType, &page.Charset, &lastModified, &page.ETag,
+			&page.Canonical, &page.NoIndex, &page.NoFollow, &page.ContentHash, &simhash, &meta)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(meta), &page.Meta); err != nil {
+			return nil, fmt.Errorf("error decoding metadata of %s: %w", key, err)
+		}
+		if simhash != "" {
+			page.SimHash, _ = strconv.ParseUint(simhash, 16, 64)
+		}
+		if lastModified != "" {
+			page.LastModified, _ = time.Parse(time.RFC3339, lastModified)
+		}
+		page.Links = []string{}
+		pages[key] = page
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	links, err := db.Query(`SELECT source, target_url FROM links ORDER BY source, position`)
+	if err != nil {
+		return nil, fmt.Errorf("error reading links from %s: %w", path, err)
+	}
+	defer links.Close()
+	for links.Next() {
+		var source, target string
+		if err := links.Scan(&source, &target); err != nil {
+			return nil, err
+		}
+		if page, ok := pages[source]; ok {
+			page.Links = append(page.Links, target)
+		}
+	}
+	return pages, links.Err()
+}
+
+// recrawlCounts tallies how the pages of an incremental crawl compare
+// with the previous crawl.
+type recrawlCounts struct {
+	unchanged int // answered 304 Not Modified
+	modified  int // known from the previous crawl and downloaded again
+	new       int // not fetched successfully by the previous crawl
+}
+
+func (cfg *config) recrawlCounts() recrawlCounts {
+	cfg.resultMu.Lock()
+	defer cfg.resultMu.Unlock()
+
+	counts := recrawlCounts{}
+	err := cfg.results.each(func(key string, page *Page) bool {
+		_, known := cfg.previous[key]
+		switch {
+		case page.NotModified:
+			counts.unchanged++
+		case known:
+			counts.modified++
+		default:
+			counts.new++
+		}
+		return true
+	})
+	if err != nil {
+		cfg.logger.Error("error reading pages", "error", err)
+	}
+	return counts
+}
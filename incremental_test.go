@@ -0,0 +1,187 @@
+package crawler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIncrementalRecrawl(t *testing.T) {
+	lastModified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	var mu sync.Mutex
+	conditional := map[string]string{}
+	bodies := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		conditional[r.URL.Path] = r.Header.Get("If-None-Match") + "|" + r.Header.Get("If-Modified-Since")
+
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("ETag", `"home-v1"`)
+			if r.Header.Get("If-None-Match") == `"home-v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			bodies++
+			fmt.Fprintln(w, createHTML("Home", []string{"/dated", "/plain"}))
+		case "/dated":
+			w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+			if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !lastModified.After(since) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			bodies++
+			fmt.Fprintln(w, createHTML("Dated", nil))
+		case "/plain":
+			bodies++
+			fmt.Fprintln(w, createHTML("Plain", nil))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "crawl.db")
+	first := newConfig([]string{server.URL}, 1, 100)
+	first.crawl(context.Background())
+	if err := first.writeSQLite(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bodies != 3 {
+		t.Fatalf("first crawl downloaded %d pages; want 3", bodies)
+	}
+
+	previous, err := loadPreviousCrawl(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := previous[normalizeURL(server.URL)].Links; len(got) != 2 {
+		t.Fatalf("stored links of the home page = %v; want 2 links", got)
+	}
+
+	bodies = 0
+	second := newConfig([]string{server.URL}, 1, 100)
+	second.previous = previous
+	second.crawl(context.Background())
+
+	if bodies != 1 {
+		t.Errorf("recrawl downloaded %d pages; want only /plain", bodies)
+	}
+
+	want := map[string]string{
+		"/":      `"home-v1"|`,
+		"/dated": "|" + lastModified.Format(http.TimeFormat),
+		"/plain": "|",
+	}
+	if !reflect.DeepEqual(conditional, want) {
+		t.Errorf("conditional headers = %v; want %v", conditional, want)
+	}
+
+	home, _ := second.result(normalizeURL(server.URL))
+	if !home.NotModified || home.StatusCode != http.StatusOK || home.Meta.Title != "Home" {
+		t.Errorf("home page = %+v; want a reused 200 page titled Home", home)
+	}
+	// Links of the unchanged home page were followed.
+	if second.results.len() != 3 {
+		t.Errorf("recrawl stored %d pages; want 3", second.results.len())
+	}
+
+	counts := second.recrawlCounts()
+	if want := (recrawlCounts{unchanged: 2, modified: 1}); counts != want {
+		t.Errorf("recrawlCounts() = %+v; want %+v", counts, want)
+	}
+
+	t.Run("Stored", func(t *testing.T) {
+		if err := second.writeSQLite(path); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		db, err := sql.Open("sqlite", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		var etag string
+		var notModified int
+		err = db.QueryRow(`SELECT etag, not_modified FROM pages WHERE url = ?`, normalizeURL(server.URL)).Scan(&etag, &notModified)
+		if err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+		if etag != `"home-v1"` || notModified != 1 {
+			t.Errorf("etag, not_modified = %s, %d; want \"home-v1\", 1", etag, notModified)
+		}
+	})
+}
+
+func TestFetchPage_UnexpectedNotModified(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	defer server.Close()
+
+	// Without validators a 304 is an error, not an unchanged page.
+	res, err := fetchPage(context.Background(), server.URL, defaultFetchLimits, validators{}, &HTTPFetcher{})
+	if err == nil || res.notModified {
+		t.Errorf("fetchPage() = %+v, %v; want a 304 error", res, err)
+	}
+}
+
+func TestLoadPreviousCrawl_Metadata(t *testing.T) {
+	meta := PageMetadata{
+		Title:       "Home",
+		Description: "The home page",
+		Robots:      "noarchive",
+		H1:          []string{"One", "Two"},
+		H1Count:     2,
+		H2:          []string{"Sub"},
+		H3:          []string{"Deeper"},
+		Lang:        "en",
+		OpenGraph:   map[string]string{"og:title": "Home"},
+		Twitter:     map[string]string{"twitter:card": "summary"},
+		WordCount:   42,
+	}
+	c := newConfig([]string{"https://example.com"}, 1, 10)
+	c.results.put("example.com", &Page{
+		URL: "https://example.com", FinalURL: "https://example.com", Seed: "https://example.com",
+		StatusCode: 200, ContentType: "text/html", ETag: `"v1"`, Links: []string{}, Meta: meta,
+	})
+	path := filepath.Join(t.TempDir(), "crawl.db")
+	if err := c.writeSQLite(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	previous, err := loadPreviousCrawl(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := previous["example.com"].Meta; !reflect.DeepEqual(got, meta) {
+		t.Errorf("metadata\n  got: %+v\n want: %+v", got, meta)
+	}
+
+	t.Run("Other schema version", func(t *testing.T) {
+		db, err := sql.Open("sqlite", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec(`UPDATE crawl_info SET value = '0' WHERE key = 'schema_version'`)
+		db.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := loadPreviousCrawl(path); err == nil {
+			t.Errorf("expected an error for an unknown schema version")
+		}
+	})
+}
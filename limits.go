@@ -0,0 +1,170 @@
+package crawler
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	// errBodyTooLarge is returned when a response body is bigger than the
+	// configured maximum and the crawler is not allowed to truncate it.
+	errBodyTooLarge = errors.New("response body too large")
+	// errDecompressionBomb is returned when a compressed body expands past
+	// the decompressed size limit or the maximum compression ratio.
+	errDecompressionBomb = errors.New("compressed body expands beyond limits")
+)
+
+// fetchLimits bounds how much data a single response may make the
+// crawler hold in memory. A zero value disables the matching limit.
+type fetchLimits struct {
+	maxBodySize         int64   // bytes read from the connection
+	truncate            bool    // keep the first maxBodySize bytes instead of failing
+	maxDecompressedSize int64   // bytes after undoing Content-Encoding
+	maxCompressionRatio float64 // decompressed bytes per compressed byte
+}
+
+var defaultFetchLimits = fetchLimits{
+	maxBodySize:         10 << 20,
+	truncate:            true,
+	maxDecompressedSize: 50 << 20,
+	maxCompressionRatio: 100,
+}
+
+// The compression ratio is only checked past this many decompressed
+// bytes, since tiny bodies routinely compress far better than real pages.
+const minRatioCheckBytes = 1 << 20
+
+// cappedReader reads at most n bytes from r (all of them when n is 0)
+// and remembers whether r had anything left past the cap.
+type cappedReader struct {
+	r        io.Reader
+	n        int64
+	read     int64
+	exceeded bool
+}
+
+func (c *cappedReader) Read(p []byte) (int, error) {
+	if c.n > 0 && c.read >= c.n {
+		var one [1]byte
+		if k, _ := io.ReadFull(c.r, one[:]); k > 0 {
+			c.exceeded = true
+		}
+		return 0, io.EOF
+	}
+	if c.n > 0 && int64(len(p)) > c.n-c.read {
+		p = p[:c.n-c.read]
+	}
+	k, err := c.r.Read(p)
+	c.read += int64(k)
+	return k, err
+}
+
+// ratioReader fails with errDecompressionBomb once the data read from r
+// outgrows the compressed bytes read so far by more than maxRatio.
+type ratioReader struct {
+	r          io.Reader
+	compressed *cappedReader
+	maxRatio   float64
+	read       int64
+}
+
+func (rr *ratioReader) Read(p []byte) (int, error) {
+	k, err := rr.r.Read(p)
+	rr.read += int64(k)
+	if rr.maxRatio > 0 && rr.read > minRatioCheckBytes &&
+		float64(rr.read) > rr.maxRatio*float64(max(rr.compressed.read, 1)) {
+		return k, errDecompressionBomb
+	}
+	return k, err
+}
+
+// bodyReader streams a response body within limits, undoing gzip or
+// deflate Content-Encoding as it goes. Going over a limit surfaces as a
+// read error, except that a body cut at maxBodySize under truncate just
+// ends early and reports truncated.
+type bodyReader struct {
+	src       io.Reader
+	wire      *cappedReader
+	decoded   *cappedReader // nil for bodies without Content-Encoding
+	closers   []io.Closer
+	limits    fetchLimits
+	truncated bool
+	err       error // first read error, other than io.EOF
+}
+
+// openBody starts reading res.Body within limits. Closing the returned
+// reader closes res.Body.
+func openBody(res *FetchResponse, limits fetchLimits) (*bodyReader, error) {
+	if limits.maxBodySize > 0 && !limits.truncate && res.ContentLength > limits.maxBodySize {
+		return nil, fmt.Errorf("%w: %d bytes announced", errBodyTooLarge, res.ContentLength)
+	}
+
+	b := &bodyReader{
+		wire:    &cappedReader{r: res.Body, n: limits.maxBodySize},
+		closers: []io.Closer{res.Body},
+		limits:  limits,
+	}
+	b.src = b.wire
+
+	encoding := strings.ToLower(strings.TrimSpace(res.Header.Get("Content-Encoding")))
+	switch encoding {
+	case "", "identity":
+	case "gzip", "x-gzip", "deflate":
+		var dec io.ReadCloser
+		var err error
+		if encoding == "deflate" {
+			dec, err = zlib.NewReader(b.wire)
+		} else {
+			dec, err = gzip.NewReader(b.wire)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error decoding %s body: %w", encoding, err)
+		}
+		b.closers = append(b.closers, dec)
+		b.decoded = &cappedReader{
+			r: &ratioReader{r: dec, compressed: b.wire, maxRatio: limits.maxCompressionRatio},
+			n: limits.maxDecompressedSize,
+		}
+		b.src = b.decoded
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+	return b, nil
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	n, err := b.src.Read(p)
+	switch {
+	case err == nil:
+		return n, nil
+	case errors.Is(err, errDecompressionBomb):
+	case b.decoded != nil && b.decoded.exceeded:
+		err = fmt.Errorf("%w: more than %d bytes", errDecompressionBomb, b.limits.maxDecompressedSize)
+	case b.wire.exceeded && !b.limits.truncate:
+		err = fmt.Errorf("%w: more than %d bytes", errBodyTooLarge, b.limits.maxBodySize)
+	case b.wire.exceeded:
+		// A cut compressed stream ends early; keep what was decoded.
+		b.truncated = true
+		return n, io.EOF
+	case err == io.EOF:
+		return n, err
+	}
+	b.err = err
+	return n, err
+}
+
+// Close closes the decoder and the response body.
+func (b *bodyReader) Close() error {
+	var errs []error
+	for i := len(b.closers) - 1; i >= 0; i-- {
+		errs = append(errs, b.closers[i].Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,162 @@
+package crawler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	zw.Close()
+	return buf.Bytes()
+}
+
+func fakeResponse(body []byte, contentEncoding string, contentLength int64) *FetchResponse {
+	header := http.Header{}
+	if contentEncoding != "" {
+		header.Set("Content-Encoding", contentEncoding)
+	}
+	return &FetchResponse{
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: contentLength,
+	}
+}
+
+// readAllBody reads a whole body through openBody.
+func readAllBody(res *FetchResponse, limits fetchLimits) ([]byte, bool, error) {
+	b, err := openBody(res, limits)
+	if err != nil {
+		return nil, false, err
+	}
+	defer b.Close()
+	body, err := io.ReadAll(b)
+	return body, b.truncated, err
+}
+
+func TestOpenBody(t *testing.T) {
+	page := []byte(strings.Repeat("<p>hello</p>", 100)) // 1200 bytes
+	bomb := gzipBytes(t, make([]byte, 8<<20))
+
+	testCases := []struct {
+		name          string
+		res           *FetchResponse
+		limits        fetchLimits
+		wantLen       int
+		wantTruncated bool
+		wantErr       error
+	}{
+		{
+			name:    "Within limits",
+			res:     fakeResponse(page, "", -1),
+			limits:  fetchLimits{maxBodySize: 2000},
+			wantLen: len(page),
+		},
+		{
+			name:    "Exactly at the limit",
+			res:     fakeResponse(page, "", -1),
+			limits:  fetchLimits{maxBodySize: int64(len(page))},
+			wantLen: len(page),
+		},
+		{
+			name:          "Truncated",
+			res:           fakeResponse(page, "", -1),
+			limits:        fetchLimits{maxBodySize: 100, truncate: true},
+			wantLen:       100,
+			wantTruncated: true,
+		},
+		{
+			name:    "Rejected while reading",
+			res:     fakeResponse(page, "", -1),
+			limits:  fetchLimits{maxBodySize: 100},
+			wantErr: errBodyTooLarge,
+		},
+		{
+			name:    "Rejected from Content-Length",
+			res:     fakeResponse(page, "", int64(len(page))),
+			limits:  fetchLimits{maxBodySize: 100},
+			wantErr: errBodyTooLarge,
+		},
+		{
+			name:    "Gzip body",
+			res:     fakeResponse(gzipBytes(t, page), "gzip", -1),
+			limits:  defaultFetchLimits,
+			wantLen: len(page),
+		},
+		{
+			name:    "Gzip over decompressed size",
+			res:     fakeResponse(gzipBytes(t, page), "gzip", -1),
+			limits:  fetchLimits{maxDecompressedSize: 500},
+			wantErr: errDecompressionBomb,
+		},
+		{
+			name:    "Gzip over compression ratio",
+			res:     fakeResponse(bomb, "gzip", -1),
+			limits:  fetchLimits{maxCompressionRatio: 100},
+			wantErr: errDecompressionBomb,
+		},
+		{
+			name:    "Unlimited",
+			res:     fakeResponse(bomb, "gzip", -1),
+			limits:  fetchLimits{},
+			wantLen: 8 << 20,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			body, truncated, err := readAllBody(tc.res, tc.limits)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(body) != tc.wantLen || truncated != tc.wantTruncated {
+				t.Errorf("body = %d bytes, truncated %v; want %d bytes, truncated %v",
+					len(body), truncated, tc.wantLen, tc.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestCrawlPage_RecordsTruncation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(createHTML("Big", []string{"/a"}) + strings.Repeat("<p>filler</p>", 1000)))
+	}))
+	defer server.Close()
+
+	c := newConfig([]string{server.URL}, 1, 1)
+	c.limits = fetchLimits{maxBodySize: 1000, truncate: true}
+	c.crawl(context.Background())
+
+	result, _ := c.result(normalizeURL(server.URL))
+	if result == nil || !result.Truncated || result.Err != "" {
+		t.Errorf("expected a truncated page without error, got %+v", result)
+	}
+
+	c = newConfig([]string{server.URL}, 1, 1)
+	c.limits = fetchLimits{maxBodySize: 1000}
+	c.crawl(context.Background())
+
+	result, _ = c.result(normalizeURL(server.URL))
+	if result == nil || !strings.Contains(result.Err, errBodyTooLarge.Error()) {
+		t.Errorf("expected the page to be rejected as too large, got %+v", result)
+	}
+}
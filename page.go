@@ -0,0 +1,61 @@
+package crawler
+
+import (
+	"net/http"
+	"time"
+)
+
+// Page holds everything the crawler learned about a single page.
+// One is stored per normalized URL once the page has been requested,
+// whether the request succeeded or not.
+type Page struct {
+	URL          string       // URL as it was discovered
+	FinalURL     string       // URL after following redirects
+	Seed         string       // seed URL whose scope the page belongs to
+	Depth        int          // number of hops from the seed URL
+	StatusCode   int          // 0 when the request never got a response
+	ContentType  string       // Content-Type response header
+	Charset      string       // detected charset of the body, e.g. "utf-8"
+	Truncated    bool         // body was cut at the configured size limit
+	LastModified time.Time    // zero when the server sent no Last-Modified
+	ETag         string       // ETag response header, if sent
+	NotModified  bool         // unchanged since the previous crawl, details reused
+	Canonical    string       // absolute rel=canonical URL, if declared
+	NoIndex      bool         // page asked not to be indexed
+	NoFollow     bool         // page asked for its links not to be followed
+	Links        []string     // absolute URLs of every link on the page
+	Meta         PageMetadata // title, description, headings and the like
+	ContentHash  string       // hex SHA-256 of the main text, empty if none
+	SimHash      uint64       // SimHash of the main text, for near-duplicates
+	Redirects    []Redirect   // redirects followed, in order
+	Err          string       // fetch error, empty on success
+}
+
+// Redirect is one redirect followed while fetching a page.
+type Redirect struct {
+	From       string
+	To         string
+	StatusCode int
+}
+
+// isCanonical reports whether the page either declares no canonical URL
+// or declares itself as canonical.
+func (p *Page) isCanonical() bool {
+	if p.Canonical == "" {
+		return true
+	}
+	return normalizeURL(p.Canonical) == normalizeURL(p.FinalURL)
+}
+
+// parseLastModified parses an HTTP date header, returning the zero time
+// when the header is empty or malformed.
+func parseLastModified(value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
@@ -0,0 +1,132 @@
+package crawler
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Progress is a snapshot of a running crawl.
+type Progress struct {
+	Fetched        int            // pages fetched, including those of a resumed crawl
+	Failed         int            // pages that could not be fetched
+	Queued         int            // pages waiting in the frontier
+	Active         int            // workers fetching a page right now
+	Bytes          int64          // body bytes downloaded, as sent on the wire
+	Elapsed        time.Duration  // time since Run started
+	PagesPerSecond float64        // pages finished per second since Run started
+	MaxPages       int            // page budget of the crawl
+	ETA            time.Duration  // time left to reach MaxPages at the current rate, 0 when unknown
+	HostQueues     map[string]int // queued pages per host
+}
+
+// Done returns the pages finished so far, fetched or failed.
+func (p Progress) Done() int {
+	return p.Fetched + p.Failed
+}
+
+// String summarizes the progress on one line.
+func (p Progress) String() string {
+	eta := "-"
+	if p.ETA > 0 {
+		eta = p.ETA.Round(time.Second).String()
+	}
+	return fmt.Sprintf("%d/%d pages, %d failed, %d queued, %d active, %.1f pages/s, %s, ETA %s",
+		p.Done(), p.MaxPages, p.Failed, p.Queued, p.Active, p.PagesPerSecond, formatBytes(int(p.Bytes)), eta)
+}
+
+// Hosts returns the hosts with the most queued pages, busiest first, at
+// most n of them.
+func (p Progress) Hosts(n int) []string {
+	hosts := slices.Collect(maps.Keys(p.HostQueues))
+	slices.SortFunc(hosts, func(a, b string) int {
+		if p.HostQueues[a] != p.HostQueues[b] {
+			return p.HostQueues[b] - p.HostQueues[a]
+		}
+		return strings.Compare(a, b)
+	})
+	return hosts[:min(n, len(hosts))]
+}
+
+// progressCounts is what the crawl keeps up to date for Progress. The
+// host queues belong to the frontier, under cfg.mu; the rest is about
+// finished pages, under cfg.resultMu.
+type progressCounts struct {
+	started    time.Time
+	doneBefore int // pages finished before Run, by a resumed crawl
+	fetched    int
+	failed     int
+	hostQueues map[string]int
+}
+
+// queueHost counts one more queued page for the host of rawURL.
+func (p *progressCounts) queueHost(rawURL string) {
+	if p.hostQueues == nil {
+		p.hostQueues = map[string]int{}
+	}
+	p.hostQueues[urlHost(rawURL)]++
+}
+
+// dequeueHost counts one page less queued for the host of rawURL.
+func (p *progressCounts) dequeueHost(rawURL string) {
+	host := urlHost(rawURL)
+	if p.hostQueues[host] <= 1 {
+		delete(p.hostQueues, host)
+		return
+	}
+	p.hostQueues[host]--
+}
+
+// count records a finished page.
+func (p *progressCounts) count(page *Page) {
+	if page.Err != "" {
+		p.failed++
+	} else {
+		p.fetched++
+	}
+}
+
+func urlHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Host)
+}
+
+func (cfg *config) progress() Progress {
+	cfg.mu.Lock()
+	p := Progress{
+		Queued:     cfg.queue.len(),
+		Active:     cfg.active,
+		MaxPages:   cfg.maxPages,
+		HostQueues: maps.Clone(cfg.counts.hostQueues),
+	}
+	cfg.mu.Unlock()
+
+	cfg.resultMu.Lock()
+	p.Fetched, p.Failed = cfg.counts.fetched, cfg.counts.failed
+	started, doneBefore := cfg.counts.started, cfg.counts.doneBefore
+	cfg.resultMu.Unlock()
+
+	if p.HostQueues == nil {
+		p.HostQueues = map[string]int{}
+	}
+	if cfg.metrics != nil {
+		p.Bytes = cfg.metrics.Bytes.Load()
+	}
+	if started.IsZero() {
+		return p
+	}
+	p.Elapsed = time.Since(started)
+	if p.Elapsed > 0 {
+		p.PagesPerSecond = float64(p.Done()-doneBefore) / p.Elapsed.Seconds()
+	}
+	if remaining := p.MaxPages - p.Done(); remaining > 0 && p.PagesPerSecond > 0 {
+		p.ETA = time.Duration(float64(remaining) / p.PagesPerSecond * float64(time.Second))
+	}
+	return p
+}
@@ -0,0 +1,90 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestProgress(t *testing.T) {
+	server := createMockServer(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, createHTML("Home", []string{"/a", "/b", "/missing"}))
+		case "/a", "/b":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, createHTML(r.URL.Path, nil))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer server.Close()
+
+	// Check the host queues while the seed's links wait to be fetched.
+	var queued map[string]int
+	var c *Crawler
+	hooks := Hooks{
+		OnPage: func(page *Page, doc *Document) {
+			if page.Depth == 0 {
+				queued = c.Progress().HostQueues
+			}
+		},
+	}
+	c, err := New([]string{server.URL}, WithMaxConcurrency(1), WithMaxPages(10), WithHooks(hooks))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer c.Close()
+	if _, err := c.Run(context.Background()); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	host := urlHost(server.URL)
+	if queued[host] != 3 {
+		t.Errorf("queued for %s while crawling the seed = %v, want 3", host, queued)
+	}
+
+	p := c.Progress()
+	if p.Fetched != 3 || p.Failed != 1 || p.Done() != 4 {
+		t.Errorf("fetched %d, failed %d, want 3 and 1", p.Fetched, p.Failed)
+	}
+	if p.Queued != 0 || p.Active != 0 || len(p.HostQueues) != 0 {
+		t.Errorf("queued %d, active %d, hosts %v after the crawl, want none", p.Queued, p.Active, p.HostQueues)
+	}
+	if p.Bytes == 0 || p.Elapsed == 0 || p.PagesPerSecond == 0 {
+		t.Errorf("progress %+v lacks bytes, elapsed time or rate", p)
+	}
+	if p.ETA <= 0 {
+		t.Errorf("ETA = %v with 6 pages of budget left, want it positive", p.ETA)
+	}
+}
+
+func TestProgressETA(t *testing.T) {
+	cfg := newConfig([]string{"https://example.com"}, 1, 100)
+	cfg.counts.started = time.Now().Add(-10 * time.Second)
+	cfg.counts.fetched = 50
+
+	p := cfg.progress()
+	if p.PagesPerSecond < 4.9 || p.PagesPerSecond > 5 {
+		t.Errorf("PagesPerSecond = %v, want about 5", p.PagesPerSecond)
+	}
+	if p.ETA < 9*time.Second || p.ETA > 11*time.Second {
+		t.Errorf("ETA = %v, want about 10s", p.ETA)
+	}
+
+	cfg.counts.doneBefore = 50
+	if p := cfg.progress(); p.PagesPerSecond != 0 || p.ETA != 0 {
+		t.Errorf("pages done before the run counted towards the rate: %+v", p)
+	}
+}
+
+func TestProgressHosts(t *testing.T) {
+	p := Progress{HostQueues: map[string]int{"b.example": 3, "a.example": 3, "c.example": 9}}
+	got := p.Hosts(2)
+	if len(got) != 2 || got[0] != "c.example" || got[1] != "a.example" {
+		t.Errorf("Hosts(2) = %v, want [c.example a.example]", got)
+	}
+}
@@ -0,0 +1,89 @@
+package crawler
+
+import (
+	"net/http"
+	"strings"
+)
+
+// robotsName is the user agent token the crawler answers to in
+// bot-specific directives such as <meta name="crawler" content="noindex">.
+const robotsName = "crawler"
+
+// robotsDirectives are the page-level indexing rules a page asked for.
+type robotsDirectives struct {
+	noindex  bool
+	nofollow bool
+}
+
+func (d robotsDirectives) merge(other robotsDirectives) robotsDirectives {
+	return robotsDirectives{
+		noindex:  d.noindex || other.noindex,
+		nofollow: d.nofollow || other.nofollow,
+	}
+}
+
+// Directives that may appear in robots meta tags and X-Robots-Tag headers.
+// Anything else before a colon in a header is taken to be a bot name.
+var knownRobotsDirectives = map[string]bool{
+	"all":               true,
+	"none":              true,
+	"noindex":           true,
+	"nofollow":          true,
+	"index":             true,
+	"follow":            true,
+	"noarchive":         true,
+	"nocache":           true,
+	"nosnippet":         true,
+	"notranslate":       true,
+	"noimageindex":      true,
+	"indexifembedded":   true,
+	"unavailable_after": true,
+	"max-snippet":       true,
+	"max-image-preview": true,
+	"max-video-preview": true,
+}
+
+// parseRobotsDirectives reads a comma separated directive list, as found
+// in the content of a robots meta tag.
+func parseRobotsDirectives(content string) robotsDirectives {
+	d := robotsDirectives{}
+	for _, part := range strings.Split(content, ",") {
+		switch strings.ToLower(strings.TrimSpace(part)) {
+		case "noindex":
+			d.noindex = true
+		case "nofollow":
+			d.nofollow = true
+		case "none":
+			d.noindex = true
+			d.nofollow = true
+		}
+	}
+	return d
+}
+
+// isRobotsMetaName reports whether a <meta name> carries directives for
+// this crawler, either for every robot or for this one in particular.
+func isRobotsMetaName(name string) bool {
+	name = strings.ToLower(strings.TrimSpace(name))
+	return name == "robots" || name == robotsName
+}
+
+// parseXRobotsTag reads every X-Robots-Tag header of a response. Values
+// may be prefixed with a bot name ("googlebot: noindex"); those are only
+// applied when the name is robotsName.
+func parseXRobotsTag(header http.Header) robotsDirectives {
+	d := robotsDirectives{}
+	for _, value := range header.Values("X-Robots-Tag") {
+		if bot, rest, found := strings.Cut(value, ":"); found {
+			bot = strings.ToLower(strings.TrimSpace(bot))
+			if !knownRobotsDirectives[bot] {
+				if bot == robotsName {
+					d = d.merge(parseRobotsDirectives(rest))
+				}
+				continue
+			}
+		}
+		d = d.merge(parseRobotsDirectives(value))
+	}
+	return d
+}
@@ -0,0 +1,116 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseRobotsDirectives(t *testing.T) {
+	testCases := []struct {
+		content  string
+		expected robotsDirectives
+	}{
+		{"", robotsDirectives{}},
+		{"index, follow", robotsDirectives{}},
+		{"NOINDEX", robotsDirectives{noindex: true}},
+		{"noarchive,nofollow", robotsDirectives{nofollow: true}},
+		{" none ", robotsDirectives{noindex: true, nofollow: true}},
+		{"max-snippet:20, noindex", robotsDirectives{noindex: true}},
+	}
+
+	for _, tc := range testCases {
+		if actual := parseRobotsDirectives(tc.content); actual != tc.expected {
+			t.Errorf("parseRobotsDirectives(%q) = %+v; want %+v", tc.content, actual, tc.expected)
+		}
+	}
+}
+
+func TestParseXRobotsTag(t *testing.T) {
+	testCases := []struct {
+		name     string
+		values   []string
+		expected robotsDirectives
+	}{
+		{"No header", nil, robotsDirectives{}},
+		{"All robots", []string{"noindex, nofollow"}, robotsDirectives{noindex: true, nofollow: true}},
+		{"Other bot", []string{"googlebot: noindex"}, robotsDirectives{}},
+		{"This bot", []string{"Crawler: nofollow"}, robotsDirectives{nofollow: true}},
+		{"Several headers", []string{"otherbot: nofollow", "noindex"}, robotsDirectives{noindex: true}},
+		{"Directive with colon", []string{"unavailable_after: 25 Jun 2010 15:00:00 PST"}, robotsDirectives{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			header := http.Header{}
+			for _, v := range tc.values {
+				header.Add("X-Robots-Tag", v)
+			}
+			if actual := parseXRobotsTag(header); actual != tc.expected {
+				t.Errorf("parseXRobotsTag(%q) = %+v; want %+v", tc.values, actual, tc.expected)
+			}
+		})
+	}
+}
+
+func TestCrawlPage_RobotsDirectives(t *testing.T) {
+	pages := map[string]string{
+		"/":         createHTML("Index", []string{"/meta", "/header", "/bot"}),
+		"/meta":     `<html><head><meta name="robots" content="nofollow"></head><body><a href="/from-meta">x</a></body></html>`,
+		"/bot":      `<html><head><meta name="crawler" content="noindex"></head><body><a href="/from-bot">x</a></body></html>`,
+		"/header":   createHTML("Header", []string{"/from-header"}),
+		"/from-bot": createHTML("From bot", nil),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/header" {
+			w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintln(w, pages[r.URL.Path])
+	}))
+	defer server.Close()
+
+	crawl := func(ignore bool) *config {
+		c := newConfig([]string{server.URL}, 1, 100)
+		c.ignoreRobotsMeta = ignore
+		c.crawl(context.Background())
+		return c
+	}
+
+	host := normalizeURL(server.URL)
+	c := crawl(false)
+	for path, want := range map[string]robotsDirectives{
+		"/meta":   {nofollow: true},
+		"/header": {noindex: true, nofollow: true},
+		"/bot":    {noindex: true},
+	} {
+		result, _ := c.result(host + path)
+		if result == nil || result.NoIndex != want.noindex || result.NoFollow != want.nofollow {
+			t.Errorf("%s: got %+v, want %+v", path, result, want)
+		}
+	}
+	for _, path := range []string{"/from-meta", "/from-header"} {
+		if _, ok := c.result(host + path); ok {
+			t.Errorf("%s should not be crawled: its only link is on a nofollow page", path)
+		}
+	}
+	if _, ok := c.result(host + "/from-bot"); !ok {
+		t.Errorf("links on noindex pages should still be followed")
+	}
+
+	entries, _ := c.sitemapEntries(PriorityNone)
+	for _, e := range entries {
+		if normalizeURL(e.Loc) == host+"/bot" || normalizeURL(e.Loc) == host+"/header" {
+			t.Errorf("noindex page %s listed in the sitemap", e.Loc)
+		}
+	}
+
+	c = crawl(true)
+	for _, path := range []string{"/from-meta", "/from-header"} {
+		if _, ok := c.result(host + path); !ok {
+			t.Errorf("%s should be crawled when robots directives are ignored", path)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package crawler
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// seedFor returns the seed whose scope contains rawURL. Each seed's scope
+// is its own host, and checkSeedHosts keeps two seeds from sharing one.
+func (cfg *config) seedFor(rawURL string) (string, bool) {
+	for _, seed := range cfg.seeds {
+		if sameDomain(seed, rawURL) {
+			return seed, true
+		}
+	}
+	return "", false
+}
+
+// checkSeedHosts returns an error when two seeds share a host: every page
+// there would be credited to the first, leaving the other one empty.
+func checkSeedHosts(seeds []string) error {
+	hosts := map[string]string{}
+	for _, seed := range seeds {
+		u, err := url.Parse(strings.ToLower(seed))
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		if first, ok := hosts[u.Hostname()]; ok {
+			return fmt.Errorf("seeds %s and %s share the host %s", first, seed, u.Hostname())
+		}
+		hosts[u.Hostname()] = seed
+	}
+	return nil
+}
+
+// ReadSeedsFile reads one seed URL per line. Blank lines and lines
+// starting with '#' are skipped.
+func ReadSeedsFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	seeds := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		seeds = append(seeds, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading seeds file %s: %w", path, err)
+	}
+	return seeds, nil
+}
@@ -0,0 +1,111 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSeedFor(t *testing.T) {
+	c := newConfig([]string{"https://example.com", "https://blog.example.org/start"}, 1, 10)
+
+	testCases := []struct {
+		rawURL   string
+		wantSeed string
+		wantOK   bool
+	}{
+		{"https://example.com/about", "https://example.com", true},
+		{"http://EXAMPLE.com:8080/", "https://example.com", true},
+		{"https://blog.example.org/post/1", "https://blog.example.org/start", true},
+		{"https://example.org/", "", false},
+		{"/relative", "", false},
+	}
+
+	for _, tc := range testCases {
+		seed, ok := c.seedFor(tc.rawURL)
+		if seed != tc.wantSeed || ok != tc.wantOK {
+			t.Errorf("seedFor(%q) = (%q, %v); want (%q, %v)", tc.rawURL, seed, ok, tc.wantSeed, tc.wantOK)
+		}
+	}
+}
+
+func TestCheckSeedHosts(t *testing.T) {
+	testCases := []struct {
+		name    string
+		seeds   []string
+		wantErr bool
+	}{
+		{"distinct hosts", []string{"https://example.com", "https://blog.example.com/start"}, false},
+		{"same host", []string{"https://example.com", "http://EXAMPLE.com:8080/other"}, true},
+		{"same URL twice", []string{"https://example.com", "https://example.com"}, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkSeedHosts(tc.seeds)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("checkSeedHosts(%v) = %v; want error %v", tc.seeds, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadSeedsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seeds.txt")
+	content := "# sites to crawl\nhttps://example.com\n\n   https://example.org/blog  \n#https://skipped.com\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	seeds, err := ReadSeedsFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"https://example.com", "https://example.org/blog"}
+	if !reflect.DeepEqual(seeds, want) {
+		t.Errorf("ReadSeedsFile() = %v; want %v", seeds, want)
+	}
+
+	if _, err := ReadSeedsFile(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+}
+
+func TestCrawlPage_MultipleSeeds(t *testing.T) {
+	serverA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintln(w, createHTML("A", []string{"/a1", "/a2"}))
+	}))
+	defer serverA.Close()
+	serverB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintln(w, createHTML("B", []string{"/b1"}))
+	}))
+	defer serverB.Close()
+
+	// Both test servers listen on 127.0.0.1; reach the second one through
+	// "localhost" so each seed has a host of its own.
+	seedA := serverA.URL
+	seedB := strings.Replace(serverB.URL, "127.0.0.1", "localhost", 1)
+
+	c := newConfig([]string{seedA, seedB}, 2, 100)
+	c.crawl(context.Background())
+
+	countBySeed := map[string]int{}
+	c.results.each(func(key string, result *Page) bool {
+		countBySeed[result.Seed]++
+		if result.Seed == seedB && !strings.HasPrefix(key, "localhost") {
+			t.Errorf("page %s attributed to seed %s", key, seedB)
+		}
+		return true
+	})
+	if countBySeed[seedA] != 3 || countBySeed[seedB] != 2 {
+		t.Errorf("pages per seed = %v; want 3 for %s and 2 for %s", countBySeed, seedA, seedB)
+	}
+}
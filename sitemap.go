@@ -0,0 +1,257 @@
+package crawler
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Limits from the sitemaps.org protocol. A single sitemap file may not
+// hold more than 50,000 URLs nor be larger than 50MB uncompressed.
+const (
+	sitemapMaxURLs  = 50000
+	sitemapMaxBytes = 50 * 1024 * 1024
+)
+
+const (
+	sitemapHeader      = `<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` + "\n"
+	sitemapFooter      = "</urlset>\n"
+	sitemapIndexHeader = `<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` + "\n"
+	sitemapIndexFooter = "</sitemapindex>\n"
+)
+
+// Ways of filling the optional <priority> element.
+const (
+	PriorityNone     = "none"
+	PriorityDepth    = "depth"
+	PriorityPageRank = "pagerank"
+)
+
+// SitemapEntry is one <url> of a sitemap.
+type SitemapEntry struct {
+	Loc      string
+	LastMod  time.Time // omitted when zero
+	Priority float64   // omitted when negative
+}
+
+// sitemapEntries returns one entry per page worth listing in a sitemap:
+// pages that answered 200 with HTML, are not marked noindex (unless
+// robots directives are being ignored) and are their own canonical.
+// Entries are sorted by URL.
+func (cfg *config) sitemapEntries(priorityMode string) ([]SitemapEntry, error) {
+	var ranks map[string]float64
+	switch priorityMode {
+	case PriorityNone, "":
+	case PriorityDepth:
+	case PriorityPageRank:
+		links := map[string][]string{}
+		err := cfg.results.each(func(key string, page *Page) bool {
+			links[key] = page.Links
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		ranks = pageRank(links)
+	default:
+		return nil, fmt.Errorf("unknown sitemap priority mode %q", priorityMode)
+	}
+
+	maxRank := 0.0
+	for _, rank := range ranks {
+		maxRank = max(maxRank, rank)
+	}
+
+	seen := map[string]bool{}
+	entries := []SitemapEntry{}
+	err := cfg.results.each(func(key string, page *Page) bool {
+		if page.StatusCode != 200 || page.Err != "" || !page.isCanonical() {
+			return true
+		}
+		if page.NoIndex && !cfg.ignoreRobotsMeta {
+			return true
+		}
+		if !strings.Contains(strings.ToLower(page.ContentType), "text/html") {
+			return true
+		}
+
+		// Several discovered URLs may redirect to the same final page.
+		normFinal := normalizeURL(page.FinalURL)
+		if seen[normFinal] {
+			return true
+		}
+		seen[normFinal] = true
+
+		entry := SitemapEntry{
+			Loc:      page.FinalURL,
+			LastMod:  page.LastModified,
+			Priority: -1,
+		}
+		switch priorityMode {
+		case PriorityDepth:
+			entry.Priority = max(0.1, 1.0-0.1*float64(page.Depth))
+		case PriorityPageRank:
+			if maxRank > 0 {
+				entry.Priority = max(0.1, ranks[key]/maxRank)
+			}
+		}
+		entries = append(entries, entry)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Loc < entries[j].Loc
+	})
+	return entries, nil
+}
+
+func sitemapURLElement(entry SitemapEntry) []byte {
+	var b bytes.Buffer
+	b.WriteString("  <url>\n    <loc>")
+	xml.EscapeText(&b, []byte(entry.Loc))
+	b.WriteString("</loc>\n")
+	if !entry.LastMod.IsZero() {
+		fmt.Fprintf(&b, "    <lastmod>%s</lastmod>\n", entry.LastMod.UTC().Format(time.RFC3339))
+	}
+	if entry.Priority >= 0 {
+		fmt.Fprintf(&b, "    <priority>%.1f</priority>\n", entry.Priority)
+	}
+	b.WriteString("  </url>\n")
+	return b.Bytes()
+}
+
+// splitSitemap groups URL elements into chunks that each fit within the
+// per-file URL and size limits.
+func splitSitemap(entries []SitemapEntry, maxURLs, maxBytes int) [][]byte {
+	chunks := [][]byte{}
+	overhead := len(sitemapHeader) + len(sitemapFooter)
+
+	var current bytes.Buffer
+	count := 0
+	for _, entry := range entries {
+		element := sitemapURLElement(entry)
+		if count > 0 && (count >= maxURLs || overhead+current.Len()+len(element) > maxBytes) {
+			chunks = append(chunks, bytes.Clone(current.Bytes()))
+			current.Reset()
+			count = 0
+		}
+		current.Write(element)
+		count++
+	}
+	if count > 0 || len(chunks) == 0 {
+		chunks = append(chunks, bytes.Clone(current.Bytes()))
+	}
+	return chunks
+}
+
+// WriteSitemap writes entries to path. When they do not fit in a single
+// sitemap, numbered sitemaps are written next to path and path becomes a
+// sitemap index pointing at them, using baseURL as their public location.
+// It returns the names of the files written.
+func WriteSitemap(path, baseURL string, entries []SitemapEntry) ([]string, error) {
+	return writeSitemapWithLimits(path, baseURL, entries, sitemapMaxURLs, sitemapMaxBytes)
+}
+
+func writeSitemapWithLimits(path, baseURL string, entries []SitemapEntry, maxURLs, maxBytes int) ([]string, error) {
+	chunks := splitSitemap(entries, maxURLs, maxBytes)
+
+	if len(chunks) == 1 {
+		if err := writeSitemapFile(path, sitemapHeader, chunks[0], sitemapFooter); err != nil {
+			return nil, err
+		}
+		return []string{path}, nil
+	}
+
+	dir := filepath.Dir(path)
+	ext := filepath.Ext(path)
+	stem := strings.TrimSuffix(filepath.Base(path), ext)
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	written := []string{}
+	var index bytes.Buffer
+	for i, chunk := range chunks {
+		name := fmt.Sprintf("%s-%d%s", stem, i+1, ext)
+		childPath := filepath.Join(dir, name)
+		if err := writeSitemapFile(childPath, sitemapHeader, chunk, sitemapFooter); err != nil {
+			return written, err
+		}
+		written = append(written, childPath)
+
+		index.WriteString("  <sitemap>\n    <loc>")
+		xml.EscapeText(&index, []byte(baseURL+"/"+name))
+		fmt.Fprintf(&index, "</loc>\n    <lastmod>%s</lastmod>\n  </sitemap>\n", now)
+	}
+
+	if err := writeSitemapFile(path, sitemapIndexHeader, index.Bytes(), sitemapIndexFooter); err != nil {
+		return written, err
+	}
+	return append(written, path), nil
+}
+
+func writeSitemapFile(path, header string, body []byte, footer string) error {
+	content := make([]byte, 0, len(header)+len(body)+len(footer))
+	content = append(content, header...)
+	content = append(content, body...)
+	content = append(content, footer...)
+	return os.WriteFile(path, content, 0o644)
+}
+
+// pageRank scores the crawled pages, given the links found on each, by
+// the internal links between them. Pages without outgoing internal links
+// spread their rank evenly.
+func pageRank(links map[string][]string) map[string]float64 {
+	const (
+		damping    = 0.85
+		iterations = 30
+	)
+
+	n := len(links)
+	ranks := make(map[string]float64, n)
+	if n == 0 {
+		return ranks
+	}
+
+	outLinks := make(map[string][]string, n)
+	for key, pageLinks := range links {
+		targets := map[string]bool{}
+		for _, link := range pageLinks {
+			target := normalizeURL(link)
+			if _, ok := links[target]; ok && target != key {
+				targets[target] = true
+			}
+		}
+		for target := range targets {
+			outLinks[key] = append(outLinks[key], target)
+		}
+		ranks[key] = 1.0 / float64(n)
+	}
+
+	for range iterations {
+		next := make(map[string]float64, n)
+		dangling := 0.0
+		for key, rank := range ranks {
+			if len(outLinks[key]) == 0 {
+				dangling += rank
+				continue
+			}
+			share := rank / float64(len(outLinks[key]))
+			for _, target := range outLinks[key] {
+				next[target] += share
+			}
+		}
+		for key := range ranks {
+			next[key] = (1-damping)/float64(n) + damping*(next[key]+dangling/float64(n))
+		}
+		ranks = next
+	}
+	return ranks
+}
@@ -0,0 +1,164 @@
+package crawler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSitemapEntries(t *testing.T) {
+	c := newConfig([]string{"https://example.com"}, 1, 10)
+	lastMod := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	c.results.put("example.com", &Page{
+		FinalURL: "https://example.com/", StatusCode: 200, ContentType: "text/html",
+		LastModified: lastMod,
+	})
+	c.results.put("example.com/about", &Page{
+		FinalURL: "https://example.com/about", StatusCode: 200, ContentType: "text/html; charset=utf-8",
+		Depth: 1,
+	})
+	c.results.put("example.com/missing", &Page{
+		FinalURL: "https://example.com/missing", StatusCode: 404, ContentType: "text/html",
+		Err: "404 Not Found",
+	})
+	c.results.put("example.com/private", &Page{
+		FinalURL: "https://example.com/private", StatusCode: 200, ContentType: "text/html",
+		NoIndex: true,
+	})
+	c.results.put("example.com/print", &Page{
+		FinalURL: "https://example.com/print", StatusCode: 200, ContentType: "text/html",
+		Canonical: "https://example.com/about",
+	})
+	c.results.put("example.com/self", &Page{
+		FinalURL: "https://example.com/self", StatusCode: 200, ContentType: "text/html",
+		Canonical: "https://example.com/self/",
+	})
+
+	entries, err := c.sitemapEntries(PriorityDepth)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := []string{}
+	for _, e := range entries {
+		got = append(got, e.Loc)
+	}
+	want := []string{"https://example.com/", "https://example.com/about", "https://example.com/self"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("sitemap locs\n  got: %v\n want: %v", got, want)
+	}
+	if !entries[0].LastMod.Equal(lastMod) || entries[0].Priority != 1.0 {
+		t.Errorf("root entry = %+v, want lastmod %v and priority 1.0", entries[0], lastMod)
+	}
+	if entries[1].Priority != 0.9 {
+		t.Errorf("depth 1 priority = %v, want 0.9", entries[1].Priority)
+	}
+
+	if _, err := c.sitemapEntries("random"); err == nil {
+		t.Errorf("expected an error for an unknown priority mode")
+	}
+}
+
+func TestWriteSitemap_Single(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sitemap.xml")
+	entries := []SitemapEntry{
+		{Loc: "https://example.com/?a=1&b=2", Priority: -1},
+		{Loc: "https://example.com/x", LastMod: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), Priority: 0.5},
+	}
+
+	files, err := WriteSitemap(path, "https://example.com", entries)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(files) != 1 || files[0] != path {
+		t.Fatalf("expected only %s to be written, got %v", path, files)
+	}
+
+	content, _ := os.ReadFile(path)
+	for _, want := range []string{
+		"<urlset",
+		"<loc>https://example.com/?a=1&amp;b=2</loc>",
+		"<lastmod>2024-01-02T03:04:05Z</lastmod>",
+		"<priority>0.5</priority>",
+	} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("sitemap does not contain %q:\n%s", want, content)
+		}
+	}
+	if strings.Count(string(content), "<priority>") != 1 {
+		t.Errorf("negative priority should be omitted:\n%s", content)
+	}
+}
+
+func TestWriteSitemap_SplitsIntoIndex(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sitemap.xml")
+	entries := []SitemapEntry{}
+	for i := range 5 {
+		entries = append(entries, SitemapEntry{Loc: fmt.Sprintf("https://example.com/page%d", i), Priority: -1})
+	}
+
+	files, err := writeSitemapWithLimits(path, "https://example.com/", entries, 2, sitemapMaxBytes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(files) != 4 {
+		t.Fatalf("expected 3 sitemaps and an index, got %v", files)
+	}
+
+	index, _ := os.ReadFile(path)
+	if !strings.Contains(string(index), "<sitemapindex") {
+		t.Fatalf("expected %s to be a sitemap index:\n%s", path, index)
+	}
+	for i := 1; i <= 3; i++ {
+		loc := fmt.Sprintf("<loc>https://example.com/sitemap-%d.xml</loc>", i)
+		if !strings.Contains(string(index), loc) {
+			t.Errorf("index does not reference %s", loc)
+		}
+	}
+
+	last, _ := os.ReadFile(filepath.Join(dir, "sitemap-3.xml"))
+	if strings.Count(string(last), "<url>") != 1 {
+		t.Errorf("expected the last sitemap to hold 1 URL:\n%s", last)
+	}
+}
+
+func TestSplitSitemap_ByteLimit(t *testing.T) {
+	entries := []SitemapEntry{}
+	for i := range 4 {
+		entries = append(entries, SitemapEntry{Loc: fmt.Sprintf("https://example.com/%d", i), Priority: -1})
+	}
+	element := len(sitemapURLElement(entries[0]))
+	limit := len(sitemapHeader) + len(sitemapFooter) + 2*element
+
+	chunks := splitSitemap(entries, sitemapMaxURLs, limit)
+	if len(chunks) != 2 {
+		t.Fatalf("expected 2 chunks under a %d byte limit, got %d", limit, len(chunks))
+	}
+}
+
+func TestPageRank(t *testing.T) {
+	// Every page links to the hub; the hub links back to a.
+	links := map[string][]string{
+		"example.com":     {"https://example.com/hub"},
+		"example.com/a":   {"https://example.com/hub"},
+		"example.com/b":   {"https://example.com/hub", "https://other.com/"},
+		"example.com/hub": {"https://example.com/a"},
+	}
+
+	ranks := pageRank(links)
+	total := 0.0
+	for _, r := range ranks {
+		total += r
+	}
+	if total < 0.99 || total > 1.01 {
+		t.Errorf("ranks should add up to 1, got %v", total)
+	}
+	if ranks["example.com/hub"] <= ranks["example.com/a"] || ranks["example.com/a"] <= ranks["example.com/b"] {
+		t.Errorf("unexpected ordering of ranks: %v", ranks)
+	}
+}
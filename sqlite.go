@@ -0,0 +1,203 @@
+package crawler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // pure Go driver, no cgo needed
+)
+
+// sqliteSchema is the layout of a crawl database. Every page the crawler
+// requested has a row in pages, keyed by its normalized URL (host/path,
+// as in the text report); links, redirects and errors refer back to it.
+//
+//	crawl_info  key/value facts about the crawl: seeds, crawled_at, schema_version
+//	pages       one row per requested page
+//	links       one row per <a href> found, in page order
+//	redirects   one row per redirect followed while fetching a page
+//	errors      one row per page that could not be fetched or parsed
+const sqliteSchema = `
+CREATE TABLE crawl_info (
+	key   TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+
+CREATE TABLE pages (
+	url           TEXT PRIMARY KEY,  -- normalized URL, e.g. example.com/about
+	requested_url TEXT NOT NULL,     -- URL as discovered
+	final_url     TEXT,              -- URL after redirects
+	seed          TEXT NOT NULL,     -- seed whose scope the page belongs to
+	depth         INTEGER NOT NULL,  -- hops from the seed
+	status_code   INTEGER NOT NULL,  -- 0 when no response was received
+	content_type  TEXT,
+	charset       TEXT,
+	last_modified TEXT,              -- RFC 3339, NULL when not sent
+	etag          TEXT,              -- ETag header, NULL when not sent
+	not_modified  INTEGER NOT NULL,  -- 1 when revalidated with a 304 against -previous
+	canonical     TEXT,
+	noindex       INTEGER NOT NULL,  -- 0 or 1
+	nofollow      INTEGER NOT NULL,  -- 0 or 1
+	truncated     INTEGER NOT NULL,  -- 0 or 1
+	title         TEXT,
+	description   TEXT,
+	h1            TEXT,              -- first <h1>
+	h1_count      INTEGER NOT NULL,
+	meta          TEXT NOT NULL,     -- JSON of every metadata field, headings and social tags included
+	lang          TEXT,
+	word_count    INTEGER NOT NULL,
+	content_hash  TEXT,              -- hex SHA-256 of the main text
+	simhash       TEXT,              -- 16 hex digits, SimHash of the main text
+	inlinks       INTEGER NOT NULL   -- internal links found to this page
+);
+
+CREATE TABLE links (
+	source     TEXT NOT NULL REFERENCES pages(url),
+	position   INTEGER NOT NULL,     -- order of the link in the page, from 0
+	target     TEXT NOT NULL,        -- normalized target URL
+	target_url TEXT NOT NULL,        -- absolute target URL
+	internal   INTEGER NOT NULL      -- 1 when the target is in a seed's scope
+);
+CREATE INDEX links_target ON links(target);
+
+CREATE TABLE redirects (
+	url         TEXT NOT NULL REFERENCES pages(url),
+	hop         INTEGER NOT NULL,    -- 0 for the first redirect
+	from_url    TEXT NOT NULL,
+	to_url      TEXT NOT NULL,
+	status_code INTEGER NOT NULL
+);
+
+CREATE TABLE errors (
+	url         TEXT NOT NULL REFERENCES pages(url),
+	status_code INTEGER NOT NULL,
+	message     TEXT NOT NULL
+);
+`
+
+const sqliteSchemaVersion = "1"
+
+// writeSQLite stores the crawl results in a fresh SQLite database at
+// path, replacing any file already there.
+func (cfg *config) writeSQLite(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		return fmt.Errorf("error creating schema: %w", err)
+	}
+
+	counts := cfg.pageCounts()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	insertInfo, err := tx.Prepare(`INSERT INTO crawl_info (key, value) VALUES (?, ?)`)
+	if err != nil {
+		return err
+	}
+	insertPage, err := tx.Prepare(`INSERT INTO pages (url, requested_url, final_url, seed, depth,
+		status_code, content_type, charset, last_modified, etag, not_modified, canonical, noindex, nofollow,
+		truncated, title, description, h1, h1_count, meta, lang, word_count, content_hash, simhash, inlinks)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	insertLink, err := tx.Prepare(`INSERT INTO links (source, position, target, target_url, internal) VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	insertRedirect, err := tx.Prepare(`INSERT INTO redirects (url, hop, from_url, to_url, status_code) VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	insertError, err := tx.Prepare(`INSERT INTO errors (url, status_code, message) VALUES (?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+
+	info := [][2]string{
+		{"schema_version", sqliteSchemaVersion},
+		{"seeds", strings.Join(cfg.seeds, "\n")},
+		{"crawled_at", time.Now().UTC().Format(time.RFC3339)},
+	}
+	for _, kv := range info {
+		if _, err := insertInfo.Exec(kv[0], kv[1]); err != nil {
+			return err
+		}
+	}
+
+	err = eachResult(cfg.results, func(key string, page *Page) error {
+		h1 := ""
+		if len(page.Meta.H1) > 0 {
+			h1 = page.Meta.H1[0]
+		}
+		meta, err := json.Marshal(page.Meta)
+		if err != nil {
+			return fmt.Errorf("error encoding metadata of %s: %w", key, err)
+		}
+		_, err = insertPage.Exec(key, page.URL, nullString(page.FinalURL), page.Seed, page.Depth,
+			page.StatusCode, nullString(page.ContentType), nullString(page.Charset), nullTime(page.LastModified),
+			nullString(page.ETag), page.NotModified, nullString(page.Canonical), page.NoIndex, page.NoFollow, page.Truncated,
+			nullString(page.Meta.Title), nullString(page.Meta.Description), nullString(h1), page.Meta.H1Count, string(meta),
+			nullString(page.Meta.Lang), page.Meta.WordCount, nullString(page.ContentHash), nullSimHash(page), counts[key])
+		if err != nil {
+			return fmt.Errorf("error storing page %s: %w", key, err)
+		}
+
+		for i, link := range page.Links {
+			_, internal := cfg.seedFor(link)
+			if _, err := insertLink.Exec(key, i, normalizeURL(link), link, internal); err != nil {
+				return err
+			}
+		}
+		for i, hop := range page.Redirects {
+			if _, err := insertRedirect.Exec(key, i, hop.From, hop.To, hop.StatusCode); err != nil {
+				return err
+			}
+		}
+		if page.Err != "" {
+			message := strings.TrimSpace(page.Err)
+			if _, err := insertError.Exec(key, page.StatusCode, message); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullSimHash(page *Page) sql.NullString {
+	if page.ContentHash == "" {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: fmt.Sprintf("%016x", page.SimHash), Valid: true}
+}
+
+func nullTime(t time.Time) sql.NullString {
+	if t.IsZero() {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: t.UTC().Format(time.RFC3339), Valid: true}
+}
@@ -0,0 +1,82 @@
+package crawler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteSQLite(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Last-Modified", "Wed, 01 May 2024 12:00:00 GMT")
+			fmt.Fprintln(w, `<html lang="en"><head><title>Home</title></head><body>
+				<h1>Welcome</h1><a href="/old">old</a><a href="/missing">missing</a><a href="https://other.com/">out</a>
+			</body></html>`)
+		case "/old":
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+		case "/new":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintln(w, createHTML("New", []string{"/"}))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	c := newConfig([]string{server.URL}, 1, 100)
+	c.crawl(context.Background())
+
+	path := filepath.Join(t.TempDir(), "crawl.db")
+	if err := c.writeSQLite(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Writing again replaces the database instead of failing.
+	if err := c.writeSQLite(path); err != nil {
+		t.Fatalf("unexpected error rewriting the database: %v", err)
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	host := normalizeURL(server.URL)
+	queries := []struct {
+		name  string
+		query string
+		args  []any
+		want  any
+	}{
+		{"Pages", `SELECT count(*) FROM pages`, nil, int64(3)},
+		{"Title", `SELECT title FROM pages WHERE url = ?`, []any{host}, "Home"},
+		{"Lang and h1", `SELECT lang || '/' || h1 || '/' || h1_count FROM pages WHERE url = ?`, []any{host}, "en/Welcome/1"},
+		{"Last-Modified", `SELECT last_modified FROM pages WHERE url = ?`, []any{host}, "2024-05-01T12:00:00Z"},
+		{"Content hashes", `SELECT length(content_hash) || ' ' || length(simhash) FROM pages WHERE url = ?`, []any{host}, "64 16"},
+		{"Inlinks", `SELECT inlinks FROM pages WHERE url = ?`, []any{host}, int64(2)},
+		{"Links", `SELECT count(*) FROM links WHERE source = ?`, []any{host}, int64(3)},
+		{"External link", `SELECT internal FROM links WHERE target_url = 'https://other.com/'`, nil, int64(0)},
+		{"Redirect", `SELECT status_code || ' ' || to_url FROM redirects WHERE url = ?`, []any{host + "/old"}, "301 " + server.URL + "/new"},
+		{"Error", `SELECT status_code || ' ' || message FROM errors`, nil, "404 404 Not Found"},
+		{"Seeds", `SELECT value FROM crawl_info WHERE key = 'seeds'`, nil, server.URL},
+	}
+
+	for _, q := range queries {
+		t.Run(q.name, func(t *testing.T) {
+			var got any
+			if err := db.QueryRow(q.query, q.args...).Scan(&got); err != nil {
+				t.Fatalf("query failed: %v", err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(q.want) {
+				t.Errorf("%s = %v; want %v", q.query, got, q.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,295 @@
+package crawler
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// frontier is the queue of pages waiting to be fetched. Implementations
+// need not be safe for concurrent use; the crawler guards them with cfg.mu.
+type frontier interface {
+	push(item crawlItem) error
+	// pop removes the oldest item. It returns false when the queue is empty.
+	pop() (crawlItem, bool, error)
+	len() int
+	// each calls fn for the queued items in the order they will be
+	// fetched, until fn returns false.
+	each(fn func(item crawlItem) bool) error
+	close() error
+}
+
+// seenSet remembers every URL admitted into the crawl and how many
+// internal links pointed at it. Unlike frontier, implementations must be
+// safe for concurrent use: workers call visit and len without cfg.mu.
+type seenSet interface {
+	// visit counts one more link to key and reports whether it is the
+	// first time key was seen.
+	visit(key string) (bool, error)
+	// add counts links to many keys at once, as restoring a checkpoint
+	// does, without going through visit for every link.
+	add(counts map[string]int) error
+	has(key string) (bool, error)
+	len() int
+	// each calls fn for every key and its link count until fn returns false.
+	each(fn func(key string, count int) bool) error
+	close() error
+}
+
+// resultStore keeps the finished page of every URL crawled, keyed by
+// normalized URL. Implementations must be safe for concurrent use. A
+// stored page must not be modified: the disk backend hands out copies.
+type resultStore interface {
+	put(key string, page *Page) error
+	// get returns the page stored under key; false when there is none.
+	get(key string) (*Page, bool, error)
+	has(key string) (bool, error)
+	len() int
+	// each calls fn for every page until fn returns false. fn must not
+	// store pages.
+	each(fn func(key string, page *Page) bool) error
+	close() error
+}
+
+// Storage backends for WithStore.
+const (
+	StoreMemory = "memory"
+	StoreDisk   = "disk"
+)
+
+// stores are the three parts of the crawl state a backend holds.
+type stores struct {
+	queue   frontier
+	pages   seenSet
+	results resultStore
+}
+
+// openStores returns the stores of the named backend. The disk backend
+// keeps its data in dir, which must not hold the stores of another crawl.
+func openStores(backend, dir string) (stores, error) {
+	switch backend {
+	case StoreMemory, "":
+		return stores{&memFrontier{}, newShardedSeenSet(), newMemResults()}, nil
+	case StoreDisk:
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return stores{}, err
+		}
+		store, err := openDiskStore(filepath.Join(dir, diskStoreFile))
+		if err != nil {
+			return stores{}, err
+		}
+		return stores{store.frontier(), store.seenSet(), store.results()}, nil
+	default:
+		return stores{}, fmt.Errorf("unknown store backend %q", backend)
+	}
+}
+
+// memFrontier is a FIFO queue held in memory.
+type memFrontier struct {
+	items []crawlItem
+	head  int
+}
+
+func (f *memFrontier) push(item crawlItem) error {
+	f.items = append(f.items, item)
+	return nil
+}
+
+func (f *memFrontier) pop() (crawlItem, bool, error) {
+	if f.head == len(f.items) {
+		return crawlItem{}, false, nil
+	}
+	item := f.items[f.head]
+	f.items[f.head] = crawlItem{}
+	f.head++
+
+	// Reclaim the consumed prefix once it dominates the slice.
+	if f.head > 1024 && f.head*2 > len(f.items) {
+		f.items = append([]crawlItem(nil), f.items[f.head:]...)
+		f.head = 0
+	}
+	return item, true, nil
+}
+
+func (f *memFrontier) len() int {
+	return len(f.items) - f.head
+}
+
+func (f *memFrontier) each(fn func(item crawlItem) bool) error {
+	for _, item := range f.items[f.head:] {
+		if !fn(item) {
+			break
+		}
+	}
+	return nil
+}
+
+func (f *memFrontier) close() error {
+	return nil
+}
+
+// seenShards is the number of independently locked parts of the
+// in-memory seen-set. A power of two so a mask picks the shard.
+const seenShards = 64
+
+// shardedSeenSet is the in-memory seen-set: a map from normalized URL to
+// the number of links found to it, split by URL hash into shards with a
+// lock each so that workers rarely wait on one another. The total is kept
+// in an atomic counter so the page budget can be checked without locking.
+type shardedSeenSet struct {
+	shards [seenShards]seenShard
+	n      atomic.Int64
+}
+
+type seenShard struct {
+	mu     sync.Mutex
+	counts map[string]int
+	_      [48]byte // keep shards on separate cache lines
+}
+
+func newShardedSeenSet() *shardedSeenSet {
+	s := &shardedSeenSet{}
+	for i := range s.shards {
+		s.shards[i].counts = map[string]int{}
+	}
+	return s
+}
+
+// shard picks the shard of key with an inlined 32-bit FNV-1a hash, which
+// unlike hash/fnv does not allocate.
+func (s *shardedSeenSet) shard(key string) *seenShard {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return &s.shards[h&(seenShards-1)]
+}
+
+func (s *shardedSeenSet) visit(key string) (bool, error) {
+	shard := s.shard(key)
+	shard.mu.Lock()
+	shard.counts[key]++
+	first := shard.counts[key] == 1
+	shard.mu.Unlock()
+
+	if first {
+		s.n.Add(1)
+	}
+	return first, nil
+}
+
+func (s *shardedSeenSet) add(counts map[string]int) error {
+	for key, count := range counts {
+		shard := s.shard(key)
+		shard.mu.Lock()
+		first := shard.counts[key] == 0
+		shard.counts[key] += count
+		shard.mu.Unlock()
+
+		if first {
+			s.n.Add(1)
+		}
+	}
+	return nil
+}
+
+func (s *shardedSeenSet) has(key string) (bool, error) {
+	shard := s.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	_, ok := shard.counts[key]
+	return ok, nil
+}
+
+func (s *shardedSeenSet) len() int {
+	return int(s.n.Load())
+}
+
+func (s *shardedSeenSet) each(fn func(key string, count int) bool) error {
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mu.Lock()
+		for key, count := range shard.counts {
+			if !fn(key, count) {
+				shard.mu.Unlock()
+				return nil
+			}
+		}
+		shard.mu.Unlock()
+	}
+	return nil
+}
+
+func (s *shardedSeenSet) close() error {
+	return nil
+}
+
+// eachResult calls fn for every page in results, stopping at the first
+// error fn returns.
+func eachResult(results resultStore, fn func(key string, page *Page) error) error {
+	var fnErr error
+	err := results.each(func(key string, page *Page) bool {
+		fnErr = fn(key, page)
+		return fnErr == nil
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	return err
+}
+
+// memResults is the in-memory result store.
+type memResults struct {
+	mu    sync.RWMutex
+	pages map[string]*Page
+}
+
+func newMemResults() *memResults {
+	return &memResults{pages: map[string]*Page{}}
+}
+
+func (r *memResults) put(key string, page *Page) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pages[key] = page
+	return nil
+}
+
+func (r *memResults) get(key string) (*Page, bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	page, ok := r.pages[key]
+	return page, ok, nil
+}
+
+func (r *memResults) has(key string) (bool, error) {
+	_, ok, err := r.get(key)
+	return ok, err
+}
+
+func (r *memResults) len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.pages)
+}
+
+// each visits the pages in key order, as the disk backend does.
+func (r *memResults) each(fn func(key string, page *Page) bool) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, key := range slices.Sorted(maps.Keys(r.pages)) {
+		if !fn(key, r.pages[key]) {
+			break
+		}
+	}
+	return nil
+}
+
+func (r *memResults) close() error {
+	return nil
+}
@@ -0,0 +1,323 @@
+package crawler
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const diskStoreFile = "crawl.db"
+
+var (
+	frontierBucket = []byte("frontier")
+	seenBucket     = []byte("seen")
+	resultsBucket  = []byte("results")
+)
+
+// diskStore keeps the frontier, seen-set and results in an embedded bbolt
+// database so that their size is bounded by disk rather than memory. All
+// three share one file; it is closed when all of them have been closed.
+type diskStore struct {
+	db   *bolt.DB
+	refs int
+	mu   sync.Mutex
+}
+
+// openDiskStore opens the store at path, creating it if needed. A store
+// left behind by another crawl is an error rather than being wiped, since
+// it may still be wanted; crawls carry on from checkpoints, not stores.
+func openDiskStore(path string) (*diskStore, error) {
+	db, err := bolt.Open(path, 0o644, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Durability comes from checkpoints; syncing every write would make
+	// the store far too slow to be useful.
+	db.NoSync = true
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{frontierBucket, seenBucket, resultsBucket} {
+			b, err := tx.CreateBucketIfNotExists(name)
+			if err != nil {
+				return err
+			}
+			if k, _ := b.Cursor().First(); k != nil {
+				return fmt.Errorf("%s already holds the stores of another crawl", path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &diskStore{db: db, refs: 3}, nil
+}
+
+func (s *diskStore) release() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refs--
+	if s.refs == 0 {
+		return s.db.Close()
+	}
+	return nil
+}
+
+func (s *diskStore) frontier() *diskFrontier {
+	return &diskFrontier{store: s}
+}
+
+func (s *diskStore) seenSet() *diskSeenSet {
+	return &diskSeenSet{store: s}
+}
+
+func (s *diskStore) results() *diskResults {
+	return &diskResults{store: s}
+}
+
+// diskFrontier stores queued items under increasing sequence numbers, so
+// the first key of the bucket is always the next item to fetch.
+type diskFrontier struct {
+	store *diskStore
+	n     int
+}
+
+func (f *diskFrontier) push(item crawlItem) error {
+	value, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	err = f.store.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(frontierBucket)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		return b.Put(binary.BigEndian.AppendUint64(nil, seq), value)
+	})
+	if err == nil {
+		f.n++
+	}
+	return err
+}
+
+func (f *diskFrontier) pop() (crawlItem, bool, error) {
+	item := crawlItem{}
+	found := false
+	err := f.store.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(frontierBucket).Cursor()
+		_, value := c.First()
+		if value == nil {
+			return nil
+		}
+		if err := json.Unmarshal(value, &item); err != nil {
+			return err
+		}
+		found = true
+		return c.Delete()
+	})
+	if err != nil {
+		return crawlItem{}, false, err
+	}
+	if found {
+		f.n--
+	}
+	return item, found, nil
+}
+
+func (f *diskFrontier) len() int {
+	return f.n
+}
+
+func (f *diskFrontier) each(fn func(item crawlItem) bool) error {
+	err := f.store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(frontierBucket).ForEach(func(_, value []byte) error {
+			item := crawlItem{}
+			if err := json.Unmarshal(value, &item); err != nil {
+				return err
+			}
+			if !fn(item) {
+				return errStopIteration
+			}
+			return nil
+		})
+	})
+	if errors.Is(err, errStopIteration) {
+		return nil
+	}
+	return err
+}
+
+func (f *diskFrontier) close() error {
+	return f.store.release()
+}
+
+// diskSeenSet maps each normalized URL to its link count. bbolt
+// serializes writers, which makes visit safe for concurrent use.
+type diskSeenSet struct {
+	store *diskStore
+	n     atomic.Int64
+}
+
+func (s *diskSeenSet) visit(key string) (bool, error) {
+	first := false
+	err := s.store.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(seenBucket)
+		count := uint64(0)
+		if value := b.Get([]byte(key)); value != nil {
+			count, _ = binary.Uvarint(value)
+		}
+		first = count == 0
+		return b.Put([]byte(key), binary.AppendUvarint(nil, count+1))
+	})
+	if err != nil {
+		return false, err
+	}
+	if first {
+		s.n.Add(1)
+	}
+	return first, nil
+}
+
+// add writes all the counts in a single transaction.
+func (s *diskSeenSet) add(counts map[string]int) error {
+	added := 0
+	err := s.store.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(seenBucket)
+		added = 0
+		for key, n := range counts {
+			count := uint64(0)
+			if value := b.Get([]byte(key)); value != nil {
+				count, _ = binary.Uvarint(value)
+			}
+			if count == 0 {
+				added++
+			}
+			if err := b.Put([]byte(key), binary.AppendUvarint(nil, count+uint64(n))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.n.Add(int64(added))
+	return nil
+}
+
+func (s *diskSeenSet) has(key string) (bool, error) {
+	found := false
+	err := s.store.db.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket(seenBucket).Get([]byte(key)) != nil
+		return nil
+	})
+	return found, err
+}
+
+func (s *diskSeenSet) len() int {
+	return int(s.n.Load())
+}
+
+var errStopIteration = errors.New("stop iteration")
+
+func (s *diskSeenSet) each(fn func(key string, count int) bool) error {
+	err := s.store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(seenBucket).ForEach(func(key, value []byte) error {
+			count, _ := binary.Uvarint(value)
+			if !fn(string(key), int(count)) {
+				return errStopIteration
+			}
+			return nil
+		})
+	})
+	if errors.Is(err, errStopIteration) {
+		return nil
+	}
+	return err
+}
+
+func (s *diskSeenSet) close() error {
+	return s.store.release()
+}
+
+// diskResults stores each page as JSON under its normalized URL.
+type diskResults struct {
+	store *diskStore
+	n     atomic.Int64
+}
+
+func (r *diskResults) put(key string, page *Page) error {
+	value, err := json.Marshal(page)
+	if err != nil {
+		return err
+	}
+	added := false
+	err = r.store.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(resultsBucket)
+		added = b.Get([]byte(key)) == nil
+		return b.Put([]byte(key), value)
+	})
+	if err == nil && added {
+		r.n.Add(1)
+	}
+	return err
+}
+
+func (r *diskResults) get(key string) (*Page, bool, error) {
+	var page *Page
+	err := r.store.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(resultsBucket).Get([]byte(key))
+		if value == nil {
+			return nil
+		}
+		page = &Page{}
+		return json.Unmarshal(value, page)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return page, page != nil, nil
+}
+
+func (r *diskResults) has(key string) (bool, error) {
+	found := false
+	err := r.store.db.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket(resultsBucket).Get([]byte(key)) != nil
+		return nil
+	})
+	return found, err
+}
+
+func (r *diskResults) len() int {
+	return int(r.n.Load())
+}
+
+func (r *diskResults) each(fn func(key string, page *Page) bool) error {
+	err := r.store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(resultsBucket).ForEach(func(key, value []byte) error {
+			page := &Page{}
+			if err := json.Unmarshal(value, page); err != nil {
+				return fmt.Errorf("error decoding page %s: %w", key, err)
+			}
+			if !fn(string(key), page) {
+				return errStopIteration
+			}
+			return nil
+		})
+	})
+	if errors.Is(err, errStopIteration) {
+		return nil
+	}
+	return err
+}
+
+func (r *diskResults) close() error {
+	return r.store.release()
+}
@@ -0,0 +1,392 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func openTestStores(t *testing.T, backend string) stores {
+	t.Helper()
+	s, err := openStores(backend, t.TempDir())
+	if err != nil {
+		t.Fatalf("could not open %s store: %v", backend, err)
+	}
+	t.Cleanup(func() {
+		s.queue.close()
+		s.pages.close()
+		s.results.close()
+	})
+	return s
+}
+
+func TestFrontier(t *testing.T) {
+	for _, backend := range []string{StoreMemory, StoreDisk} {
+		t.Run(backend, func(t *testing.T) {
+			f := openTestStores(t, backend).queue
+			for i := range 3000 {
+				if err := f.push(crawlItem{URL: fmt.Sprint(i), Depth: i % 7}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for i := range 2000 {
+				item, ok, err := f.pop()
+				if err != nil || !ok || item.URL != fmt.Sprint(i) || item.Depth != i%7 {
+					t.Fatalf("pop %d = (%v, %v, %v)", i, item, ok, err)
+				}
+			}
+			if f.len() != 1000 {
+				t.Fatalf("expected 1000 queued items, got %d", f.len())
+			}
+			var queued []crawlItem
+			err := f.each(func(item crawlItem) bool {
+				queued = append(queued, item)
+				return true
+			})
+			if err != nil || len(queued) != 1000 || queued[0].URL != "2000" || queued[999].URL != "2999" {
+				t.Errorf("unexpected queued items: len %d, err %v", len(queued), err)
+			}
+			if f.len() != 1000 {
+				t.Errorf("each must not remove items, %d left", f.len())
+			}
+			for range 1000 {
+				f.pop()
+			}
+			if _, ok, _ := f.pop(); ok || f.len() != 0 {
+				t.Errorf("expected the frontier to be empty")
+			}
+		})
+	}
+}
+
+func TestSeenSet(t *testing.T) {
+	for _, backend := range []string{StoreMemory, StoreDisk} {
+		t.Run(backend, func(t *testing.T) {
+			s := openTestStores(t, backend).pages
+			for _, key := range []string{"a", "b", "a", "c", "a", "b"} {
+				s.visit(key)
+			}
+			if first, _ := s.visit("d"); !first {
+				t.Errorf("expected d to be new")
+			}
+			if first, _ := s.visit("d"); first {
+				t.Errorf("expected d to be seen already")
+			}
+			hasC, errC := s.has("c")
+			hasE, errE := s.has("e")
+			if s.len() != 4 || !hasC || hasE || errC != nil || errE != nil {
+				t.Errorf("unexpected seen-set state: len %d", s.len())
+			}
+
+			counts := map[string]int{}
+			s.each(func(key string, count int) bool {
+				counts[key] = count
+				return true
+			})
+			want := map[string]int{"a": 3, "b": 2, "c": 1, "d": 2}
+			if !reflect.DeepEqual(counts, want) {
+				t.Errorf("counts = %v; want %v", counts, want)
+			}
+
+			// add counts on top of what visit counted.
+			if err := s.add(map[string]int{"a": 2, "e": 3}); err != nil {
+				t.Fatal(err)
+			}
+			s.each(func(key string, count int) bool {
+				counts[key] = count
+				return true
+			})
+			want["a"], want["e"] = 5, 3
+			if !reflect.DeepEqual(counts, want) || s.len() != 5 {
+				t.Errorf("counts after add = %v (len %d); want %v", counts, s.len(), want)
+			}
+
+			visited := 0
+			s.each(func(string, int) bool {
+				visited++
+				return false
+			})
+			if visited != 1 {
+				t.Errorf("each should stop when fn returns false, visited %d", visited)
+			}
+		})
+	}
+}
+
+func TestResultStore(t *testing.T) {
+	for _, backend := range []string{StoreMemory, StoreDisk} {
+		t.Run(backend, func(t *testing.T) {
+			r := openTestStores(t, backend).results
+			for _, key := range []string{"example.com/b", "example.com", "example.com/a"} {
+				page := &Page{URL: "https://" + key, StatusCode: 200, Links: []string{"https://example.com"}}
+				if err := r.put(key, page); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Storing a page again replaces it.
+			if err := r.put("example.com/a", &Page{URL: "https://example.com/a", StatusCode: 404}); err != nil {
+				t.Fatal(err)
+			}
+
+			if r.len() != 3 {
+				t.Errorf("expected 3 pages, got %d", r.len())
+			}
+			page, ok, err := r.get("example.com/a")
+			if err != nil || !ok || page.StatusCode != 404 {
+				t.Errorf("get = (%+v, %v, %v)", page, ok, err)
+			}
+			if _, ok, err := r.get("example.com/c"); ok || err != nil {
+				t.Errorf("expected no page for example.com/c, got (%v, %v)", ok, err)
+			}
+			if ok, err := r.has("example.com/b"); !ok || err != nil {
+				t.Errorf("has = (%v, %v)", ok, err)
+			}
+
+			var keys []string
+			r.each(func(key string, page *Page) bool {
+				if page.URL != "https://"+key {
+					t.Errorf("page %s stored under %s", page.URL, key)
+				}
+				keys = append(keys, key)
+				return true
+			})
+			if want := []string{"example.com", "example.com/a", "example.com/b"}; !reflect.DeepEqual(keys, want) {
+				t.Errorf("each visited %v; want %v", keys, want)
+			}
+
+			visited := 0
+			r.each(func(string, *Page) bool {
+				visited++
+				return false
+			})
+			if visited != 1 {
+				t.Errorf("each should stop when fn returns false, visited %d", visited)
+			}
+		})
+	}
+}
+
+func TestOpenStores_Unknown(t *testing.T) {
+	if _, err := openStores("cloud", t.TempDir()); err == nil {
+		t.Errorf("expected an error for an unknown backend")
+	}
+}
+
+func TestOpenStores_DiskKeepsExistingData(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openStores(StoreDisk, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.results.put("example.com", &Page{URL: "https://example.com"})
+	for _, closer := range []func() error{s.queue.close, s.pages.close, s.results.close} {
+		closer()
+	}
+
+	if _, err := openStores(StoreDisk, dir); err == nil {
+		t.Fatalf("expected an error for a store holding another crawl")
+	}
+
+	// Refusing the store must leave its data alone.
+	db, err := bolt.Open(filepath.Join(dir, diskStoreFile), 0o644, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(resultsBucket).Get([]byte("example.com")) == nil {
+			t.Errorf("the stored page is gone")
+		}
+		return nil
+	})
+}
+
+func TestCrawl_DiskStore(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintln(w, createHTML(r.URL.Path, []string{"/a", "/b", "/c", "/"}))
+	}))
+	defer server.Close()
+
+	c := newConfig([]string{server.URL}, 4, 100)
+	s := openTestStores(t, StoreDisk)
+	c.queue, c.pages, c.results = s.queue, s.pages, s.results
+	c.crawl(context.Background())
+
+	host := normalizeURL(server.URL)
+	counts := c.pageCounts()
+	// Four pages, each linking to all four.
+	want := map[string]int{host: 5, host + "/a": 4, host + "/b": 4, host + "/c": 4}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("counts = %v; want %v", counts, want)
+	}
+	if c.results.len() != 4 {
+		t.Errorf("expected 4 results, got %d", c.results.len())
+	}
+}
+
+func TestShardedSeenSet_Concurrent(t *testing.T) {
+	s := newShardedSeenSet()
+	var wg sync.WaitGroup
+	var firsts atomic.Int64
+	for w := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 1000 {
+				// Every worker visits the same 1000 keys, in a different order.
+				if first, _ := s.visit(fmt.Sprint((i + w*61) % 1000)); first {
+					firsts.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firsts.Load() != 1000 || s.len() != 1000 {
+		t.Errorf("expected 1000 first visits and keys, got %d and %d", firsts.Load(), s.len())
+	}
+	total := 0
+	s.each(func(_ string, count int) bool {
+		total += count
+		return true
+	})
+	if total != 16*1000 {
+		t.Errorf("expected %d visits in total, got %d", 16*1000, total)
+	}
+}
+
+// lockedSeenSet is a map behind a single mutex, the way the crawler kept
+// seen URLs before the seen-set was sharded. It is the benchmark baseline.
+type lockedSeenSet struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (s *lockedSeenSet) visit(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts[key]++
+	return s.counts[key] == 1, nil
+}
+
+func (s *lockedSeenSet) add(counts map[string]int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, count := range counts {
+		s.counts[key] += count
+	}
+	return nil
+}
+
+func (s *lockedSeenSet) has(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.counts[key]
+	return ok, nil
+}
+
+func (s *lockedSeenSet) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.counts)
+}
+
+func (s *lockedSeenSet) each(fn func(key string, count int) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, count := range s.counts {
+		if !fn(key, count) {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *lockedSeenSet) close() error {
+	return nil
+}
+
+// BenchmarkSeenSet mimics enqueue: a budget check followed by a visit,
+// from a growing number of workers. Keys repeat, as links do in a crawl.
+func BenchmarkSeenSet(b *testing.B) {
+	keys := make([]string, 1<<16)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("example.com/section/%d/page/%d", i%97, i)
+	}
+
+	impls := []struct {
+		name string
+		new  func() seenSet
+	}{
+		{"mutex", func() seenSet { return &lockedSeenSet{counts: map[string]int{}} }},
+		{"sharded", func() seenSet { return newShardedSeenSet() }},
+		{"bloom", func() seenSet { s, _ := newBloomSeenSet(len(keys), 0.001); return s }},
+	}
+
+	for _, impl := range impls {
+		for _, workers := range []int{1, 8, 64, 256} {
+			b.Run(fmt.Sprintf("%s/workers=%d", impl.name, workers), func(b *testing.B) {
+				s := impl.new()
+				var wg sync.WaitGroup
+				b.ResetTimer()
+				for w := range workers {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						// Each worker takes every workers-th operation.
+						for i := w; i < b.N; i += workers {
+							if s.len() < len(keys)*2 {
+								s.visit(keys[(i*7919)&(len(keys)-1)])
+							}
+						}
+					}()
+				}
+				wg.Wait()
+			})
+		}
+	}
+}
+
+// BenchmarkEnqueue runs the whole of enqueue, scope and trap checks, the
+// seen-set and the frontier, from a growing number of workers. Every
+// eighth operation stores a result instead, as a worker finishing a page
+// would.
+func BenchmarkEnqueue(b *testing.B) {
+	urls := make([]string, 1<<16)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("https://example.com/section/%d/page/%d", i%97, i)
+	}
+
+	for _, workers := range []int{1, 8, 64, 256} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			c := newConfig([]string{"https://example.com"}, workers, len(urls)*2)
+			c.traps = newTrapDetector(TrapLimits{})
+			var wg sync.WaitGroup
+			b.ResetTimer()
+			for w := range workers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := w; i < b.N; i += workers {
+						u := urls[(i*7919)&(len(urls)-1)]
+						if i%8 == 0 {
+							c.storeResult(normalizeURL(u), &Page{URL: u, StatusCode: 200})
+							continue
+						}
+						c.enqueue(u, 1)
+					}
+				}()
+			}
+			wg.Wait()
+		})
+	}
+}
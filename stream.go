@@ -0,0 +1,126 @@
+package crawler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// StreamedPage is the record written for every finished page by
+// WithResultStream, one JSON object per line.
+type StreamedPage struct {
+	URL          string             `json:"url"`
+	FinalURL     string             `json:"final_url,omitempty"`
+	Seed         string             `json:"seed"`
+	Depth        int                `json:"depth"`
+	StatusCode   int                `json:"status_code"`
+	ContentType  string             `json:"content_type,omitempty"`
+	Charset      string             `json:"charset,omitempty"`
+	Truncated    bool               `json:"truncated,omitempty"`
+	LastModified *time.Time         `json:"last_modified,omitempty"`
+	ETag         string             `json:"etag,omitempty"`
+	NotModified  bool               `json:"not_modified,omitempty"`
+	Canonical    string             `json:"canonical,omitempty"`
+	NoIndex      bool               `json:"noindex,omitempty"`
+	NoFollow     bool               `json:"nofollow,omitempty"`
+	Title        string             `json:"title,omitempty"`
+	Description  string             `json:"description,omitempty"`
+	WordCount    int                `json:"word_count"`
+	ContentHash  string             `json:"content_hash,omitempty"`
+	SimHash      string             `json:"simhash,omitempty"` // 16 hex digits
+	Links        []string           `json:"links"`
+	Redirects    []StreamedRedirect `json:"redirects,omitempty"`
+	Error        string             `json:"error,omitempty"`
+	FinishedAt   time.Time          `json:"finished_at"`
+}
+
+// StreamedRedirect is one redirect of a StreamedPage.
+type StreamedRedirect struct {
+	From       string `json:"from"`
+	To         string `json:"to"`
+	StatusCode int    `json:"status_code"`
+}
+
+func newStreamedPage(page *Page, finishedAt time.Time) StreamedPage {
+	record := StreamedPage{
+		URL:         page.URL,
+		FinalURL:    page.FinalURL,
+		Seed:        page.Seed,
+		Depth:       page.Depth,
+		StatusCode:  page.StatusCode,
+		ContentType: page.ContentType,
+		Charset:     page.Charset,
+		Truncated:   page.Truncated,
+		ETag:        page.ETag,
+		NotModified: page.NotModified,
+		Canonical:   page.Canonical,
+		NoIndex:     page.NoIndex,
+		NoFollow:    page.NoFollow,
+		Title:       page.Meta.Title,
+		Description: page.Meta.Description,
+		WordCount:   page.Meta.WordCount,
+		ContentHash: page.ContentHash,
+		Links:       page.Links,
+		Error:       page.Err,
+		FinishedAt:  finishedAt.UTC(),
+	}
+	if !page.LastModified.IsZero() {
+		lastModified := page.LastModified.UTC()
+		record.LastModified = &lastModified
+	}
+	if page.ContentHash != "" {
+		record.SimHash = fmt.Sprintf("%016x", page.SimHash)
+	}
+	if record.Links == nil {
+		record.Links = []string{}
+	}
+	for _, r := range page.Redirects {
+		record.Redirects = append(record.Redirects, StreamedRedirect{From: r.From, To: r.To, StatusCode: r.StatusCode})
+	}
+	return record
+}
+
+// resultStream writes pages as NDJSON as soon as they finish. Every page
+// is a single Write, so a crash loses at most the line being written.
+type resultStream struct {
+	mu  sync.Mutex
+	w   io.Writer
+	enc *json.Encoder
+	err error // first write error; nothing more is written after it
+}
+
+func newResultStream(w io.Writer) *resultStream {
+	return &resultStream{w: w, enc: json.NewEncoder(w)}
+}
+
+func (s *resultStream) write(page *Page) {
+	record := newStreamedPage(page, time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return
+	}
+	if err := s.enc.Encode(record); err != nil {
+		s.err = fmt.Errorf("error streaming %s: %w", page.URL, err)
+		return
+	}
+	// Buffered writers are flushed line by line so consumers see each
+	// page right away.
+	if f, ok := s.w.(interface{ Flush() error }); ok {
+		if err := f.Flush(); err != nil {
+			s.err = fmt.Errorf("error streaming %s: %w", page.URL, err)
+		}
+	}
+}
+
+func (s *resultStream) hooks() Hooks {
+	return Hooks{
+		OnPage:  func(page *Page, doc *Document) { s.write(page) },
+		OnError: func(page *Page, err error) { s.write(page) },
+
+		skipDocument: true,
+	}
+}
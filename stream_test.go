@@ -0,0 +1,102 @@
+package crawler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// flushRecorder counts how often it is flushed and what was written by
+// then.
+type flushRecorder struct {
+	bytes.Buffer
+	lines []int
+}
+
+func (f *flushRecorder) Flush() error {
+	f.lines = append(f.lines, bytes.Count(f.Bytes(), []byte("\n")))
+	return nil
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestResultStream(t *testing.T) {
+	server := createMockServer(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, createHTML("Home", []string{"/a", "/missing"}))
+		case "/a":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, createHTML("A", nil))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer server.Close()
+
+	out := &flushRecorder{}
+	c, err := New([]string{server.URL}, WithMaxConcurrency(1), WithResultStream(out))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer c.Close()
+	if _, err := c.Run(context.Background()); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	records := map[string]StreamedPage{}
+	scanner := bufio.NewScanner(bytes.NewReader(out.Bytes()))
+	for scanner.Scan() {
+		var record StreamedPage
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("line %q is not JSON: %v", scanner.Text(), err)
+		}
+		records[record.URL] = record
+	}
+	if len(records) != 3 {
+		t.Fatalf("got %d records, want 3", len(records))
+	}
+
+	if home := records[server.URL]; home.Title != "Home" || len(home.Links) != 2 || home.SimHash == "" {
+		t.Errorf("home record = %+v", home)
+	}
+	if missing := records[server.URL+"/missing"]; missing.StatusCode != http.StatusNotFound || missing.Error == "" {
+		t.Errorf("missing record = %+v", missing)
+	}
+	for i, n := range out.lines {
+		if n != i+1 {
+			t.Errorf("flush %d came after %d lines, want %d", i, n, i+1)
+		}
+	}
+}
+
+func TestResultStreamError(t *testing.T) {
+	server := createMockServer(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, createHTML("Home", nil))
+	})
+	defer server.Close()
+
+	c, err := New([]string{server.URL}, WithResultStream(failingWriter{}))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer c.Close()
+	results, err := c.Run(context.Background())
+	if err == nil {
+		t.Fatal("expected the write error from Run")
+	}
+	if len(results.Pages()) != 1 {
+		t.Errorf("got %d pages, want the crawl to go on without the stream", len(results.Pages()))
+	}
+}
@@ -0,0 +1,258 @@
+package crawler
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// TrapLimits are the heuristics that keep the crawl out of infinite URL
+// spaces such as calendars, faceted navigation and recursive relative
+// links. A zero limit turns its check off.
+//
+// The checks look at each URL as discovered, query string included, but
+// only the first time its page is seen. Pages are told apart without
+// their query string, so /search?q=a and /search?q=b are one page and
+// MaxQueryParams only catches a page whose first link has too many
+// parameters.
+type TrapLimits struct {
+	MaxSegmentRepeats int // times one path segment may appear in a URL
+	MaxPathDepth      int // path segments in a URL
+	MaxQueryParams    int // query parameters in a URL
+	PatternBudget     int // URLs admitted per path pattern
+}
+
+// DefaultTrapLimits are the limits a crawl uses unless WithTrapLimits
+// sets others.
+var DefaultTrapLimits = TrapLimits{
+	MaxSegmentRepeats: 3,
+	MaxPathDepth:      15,
+	MaxQueryParams:    10,
+	PatternBudget:     1000,
+}
+
+// Kinds of suspected spider traps.
+const (
+	trapRepeatedSegments = "repeated_segments"
+	trapPathDepth        = "path_depth"
+	trapQueryParams      = "query_params"
+	trapPatternBudget    = "pattern_budget"
+)
+
+// maxTrapExamples is how many of the URLs a trap kept out are listed.
+const maxTrapExamples = 5
+
+// maxTrapPatterns bounds the path patterns the detector remembers, so its
+// memory stays flat however many URLs the crawl finds. Patterns past it
+// get no budget, and traps past it are kept out but not listed.
+const maxTrapPatterns = 100000
+
+// SuspectedTrap is one heuristic that fired, with how many links it kept
+// out of the crawl and a few of their URLs. A URL linked again while
+// still capped counts again.
+type SuspectedTrap struct {
+	Kind    string   `json:"kind"`
+	Pattern string   `json:"pattern"`
+	Count   int      `json:"count"`
+	URLs    []string `json:"urls"` // at most maxTrapExamples
+}
+
+// trapDetector applies TrapLimits to URLs as they are discovered and
+// remembers what it capped. It is safe for concurrent use.
+type trapDetector struct {
+	limits TrapLimits
+
+	mu       sync.Mutex
+	patterns map[string]int               // URLs admitted per wildcard path pattern
+	capped   map[[2]string]*SuspectedTrap // by kind and pattern
+}
+
+func newTrapDetector(limits TrapLimits) *trapDetector {
+	return &trapDetector{
+		limits:   limits,
+		patterns: map[string]int{},
+		capped:   map[[2]string]*SuspectedTrap{},
+	}
+}
+
+// admit reports whether a URL not yet seen may join the crawl, counting
+// it against its path pattern's budget when it does. A caller that finds
+// the URL was queued by someone else after all gives the slot back with
+// release.
+func (d *trapDetector) admit(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return true
+	}
+	pattern := pathPattern(u)
+	segments := pathSegments(u.Path)
+
+	kind := ""
+	switch {
+	case d.limits.MaxSegmentRepeats > 0 && maxRepeats(segments) > d.limits.MaxSegmentRepeats:
+		kind = trapRepeatedSegments
+	case d.limits.MaxPathDepth > 0 && len(segments) > d.limits.MaxPathDepth:
+		kind = trapPathDepth
+	case d.limits.MaxQueryParams > 0 && queryParamCount(u.RawQuery) > d.limits.MaxQueryParams:
+		kind = trapQueryParams
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if kind == "" {
+		// A pattern without wildcards is a single page, which the
+		// seen-set admits only once; only the others need a budget.
+		if d.limits.PatternBudget == 0 || !strings.Contains(pattern, "*") {
+			return true
+		}
+		admitted, tracked := d.patterns[pattern]
+		if admitted < d.limits.PatternBudget {
+			if tracked || len(d.patterns) < maxTrapPatterns {
+				d.patterns[pattern]++
+			}
+			return true
+		}
+		kind = trapPatternBudget
+	}
+
+	key := [2]string{kind, pattern}
+	trap := d.capped[key]
+	if trap == nil {
+		if len(d.capped) >= maxTrapPatterns {
+			return false
+		}
+		trap = &SuspectedTrap{Kind: kind, Pattern: pattern, URLs: []string{}}
+		d.capped[key] = trap
+	}
+	trap.Count++
+	if len(trap.URLs) < maxTrapExamples && !slices.Contains(trap.URLs, rawURL) {
+		trap.URLs = append(trap.URLs, rawURL)
+	}
+	return false
+}
+
+// release returns the pattern budget admit charged for rawURL.
+func (d *trapDetector) release(rawURL string) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return
+	}
+	pattern := pathPattern(u)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch d.patterns[pattern] {
+	case 0:
+	case 1:
+		delete(d.patterns, pattern)
+	default:
+		d.patterns[pattern]--
+	}
+}
+
+// traps lists the suspected traps found so far, by kind and pattern.
+func (d *trapDetector) traps() []SuspectedTrap {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	traps := []SuspectedTrap{}
+	for _, capped := range d.capped {
+		trap := *capped
+		trap.URLs = slices.Sorted(slices.Values(capped.URLs))
+		traps = append(traps, trap)
+	}
+	sort.Slice(traps, func(i, j int) bool {
+		if traps[i].Kind != traps[j].Kind {
+			return traps[i].Kind < traps[j].Kind
+		}
+		return traps[i].Pattern < traps[j].Pattern
+	})
+	return traps
+}
+
+func pathSegments(path string) []string {
+	segments := []string{}
+	for _, s := range strings.Split(path, "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+	return segments
+}
+
+// maxRepeats returns how often the most frequent segment appears.
+func maxRepeats(segments []string) int {
+	counts := map[string]int{}
+	most := 0
+	for _, s := range segments {
+		counts[s]++
+		most = max(most, counts[s])
+	}
+	return most
+}
+
+func queryParamCount(rawQuery string) int {
+	n := 0
+	for _, param := range strings.Split(rawQuery, "&") {
+		if param != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// pathPattern groups URLs that differ only in numbers or in the values
+// of their query parameters: host and path, with every segment holding a
+// digit replaced by "*", then the sorted parameter names. Both
+// /calendar/2024/05 and /calendar/1999/12 are example.com/calendar/*/*.
+func pathPattern(u *url.URL) string {
+	segments := pathSegments(u.Path)
+	for i, s := range segments {
+		if strings.IndexFunc(s, unicode.IsDigit) >= 0 {
+			segments[i] = "*"
+		}
+	}
+	pattern := strings.ToLower(u.Host) + "/" + strings.Join(segments, "/")
+
+	names := []string{}
+	for name := range u.Query() {
+		names = append(names, name)
+	}
+	if len(names) > 0 {
+		slices.Sort(names)
+		pattern += "?" + strings.Join(names, "&")
+	}
+	return pattern
+}
+
+// writeTraps adds the suspected spider traps to the text report.
+func (cfg *config) writeTraps(w io.Writer) {
+	traps := cfg.traps.traps()
+	if len(traps) == 0 {
+		return
+	}
+
+	descriptions := map[string]string{
+		trapRepeatedSegments: fmt.Sprintf("path segment repeated more than %d times", cfg.traps.limits.MaxSegmentRepeats),
+		trapPathDepth:        fmt.Sprintf("more than %d path segments", cfg.traps.limits.MaxPathDepth),
+		trapQueryParams:      fmt.Sprintf("more than %d query parameters", cfg.traps.limits.MaxQueryParams),
+		trapPatternBudget:    fmt.Sprintf("more than %d URLs with the same pattern", cfg.traps.limits.PatternBudget),
+	}
+
+	fmt.Fprintf(w, "\nSuspected spider traps (URLs not crawled):\n")
+	for _, trap := range traps {
+		fmt.Fprintf(w, "  %s: %s (%d links)\n", trap.Pattern, descriptions[trap.Kind], trap.Count)
+		for _, u := range trap.URLs {
+			fmt.Fprintf(w, "    %s\n", u)
+		}
+		if trap.Count > len(trap.URLs) {
+			fmt.Fprintf(w, "    ...\n")
+		}
+	}
+}
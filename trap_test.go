@@ -0,0 +1,183 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestPathPattern(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"https://Example.com/calendar/2024/05", "example.com/calendar/*/*"},
+		{"https://example.com/blog/post-1/", "example.com/blog/*"},
+		{"https://example.com/", "example.com/"},
+		{"https://example.com/shop?size=m&color=red", "example.com/shop?color&size"},
+		{"https://example.com/shop?color=blue&size=s&color=red", "example.com/shop?color&size"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			u, err := url.Parse(tc.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := pathPattern(u); got != tc.expected {
+				t.Errorf("pathPattern(%s) = %s; want %s", tc.input, got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestTrapDetector_Admit(t *testing.T) {
+	limits := TrapLimits{MaxSegmentRepeats: 2, MaxPathDepth: 4, MaxQueryParams: 2, PatternBudget: 2}
+
+	tests := []struct {
+		name     string
+		input    string
+		admitted bool
+		kind     string
+	}{
+		{"Plain page", "https://example.com/about", true, ""},
+		{"Segment twice", "https://example.com/a/a", true, ""},
+		{"Segment three times", "https://example.com/a/b/a/a", false, trapRepeatedSegments},
+		{"Deep path", "https://example.com/1/b/c/d/e", false, trapPathDepth},
+		{"Many parameters", "https://example.com/search?q=x&page=2&sort=asc", false, trapQueryParams},
+		{"Disabled checks", "https://example.com/a/a/a/a/a?a=1&b=2&c=3", true, ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			l := limits
+			if tc.name == "Disabled checks" {
+				l = TrapLimits{}
+			}
+			d := newTrapDetector(l)
+			if got := d.admit(tc.input); got != tc.admitted {
+				t.Errorf("admit(%s) = %v; want %v", tc.input, got, tc.admitted)
+			}
+			traps := d.traps()
+			if tc.kind == "" {
+				if len(traps) != 0 {
+					t.Errorf("traps() = %+v; want none", traps)
+				}
+				return
+			}
+			if len(traps) != 1 || traps[0].Kind != tc.kind || traps[0].URLs[0] != tc.input {
+				t.Errorf("traps() = %+v; want one %s trap for %s", traps, tc.kind, tc.input)
+			}
+		})
+	}
+
+	t.Run("Pattern budget", func(t *testing.T) {
+		d := newTrapDetector(limits)
+		for day := 1; day <= 4; day++ {
+			admitted := d.admit(fmt.Sprintf("https://example.com/calendar/2024/05/%02d", day))
+			if want := day <= 2; admitted != want {
+				t.Errorf("day %d admitted = %v; want %v", day, admitted, want)
+			}
+		}
+		if !d.admit("https://example.com/calendar") {
+			t.Errorf("a different pattern was capped")
+		}
+		traps := d.traps()
+		if len(traps) != 1 || traps[0].Pattern != "example.com/calendar/*/*/*" || traps[0].Count != 2 || len(traps[0].URLs) != 2 {
+			t.Errorf("traps() = %+v; want the two capped calendar days", traps)
+		}
+	})
+
+	t.Run("Release", func(t *testing.T) {
+		d := newTrapDetector(limits)
+		d.admit("https://example.com/item/1")
+		d.admit("https://example.com/item/2")
+		d.release("https://example.com/item/2")
+		if !d.admit("https://example.com/item/3") {
+			t.Errorf("a released slot was not given out again")
+		}
+	})
+
+	t.Run("Patterns are bounded", func(t *testing.T) {
+		d := newTrapDetector(TrapLimits{PatternBudget: 1})
+		// Slugs have no wildcard: each is one page and needs no budget.
+		for i := range 10 {
+			d.admit(fmt.Sprintf("https://example.com/post/%c-slug", 'a'+i))
+		}
+		if len(d.patterns) != 0 {
+			t.Errorf("%d patterns remembered for pages without wildcards", len(d.patterns))
+		}
+		// Each user name, spelled in letters, makes a pattern of its own.
+		name := func(i int) string {
+			b := []byte{}
+			for ; i > 0; i /= 26 {
+				b = append(b, byte('a'+i%26))
+			}
+			return string(b)
+		}
+		for i := range maxTrapPatterns + 10 {
+			d.admit(fmt.Sprintf("https://example.com/user/x%s/post/1", name(i)))
+		}
+		if len(d.patterns) != maxTrapPatterns {
+			t.Errorf("%d patterns remembered; want at most %d", len(d.patterns), maxTrapPatterns)
+		}
+	})
+
+	t.Run("Examples are capped", func(t *testing.T) {
+		d := newTrapDetector(TrapLimits{PatternBudget: 1})
+		for i := range 20 {
+			d.admit(fmt.Sprintf("https://example.com/item/%d", i))
+		}
+		d.admit("https://example.com/item/19")
+		traps := d.traps()
+		if len(traps) != 1 || traps[0].Count != 20 || len(traps[0].URLs) != maxTrapExamples {
+			t.Errorf("traps() = %+v; want 20 links counted and %d examples", traps, maxTrapExamples)
+		}
+	})
+}
+
+func TestCrawl_SpiderTrap(t *testing.T) {
+	// Every page links one level deeper, forever.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintln(w, createHTML("Trap", []string{r.URL.Path + "a/"}))
+	}))
+	defer server.Close()
+
+	c := newConfig([]string{server.URL}, 2, 100)
+	c.crawl(context.Background())
+
+	// /, /a/, /a/a/ and /a/a/a/ are crawled; /a/a/a/a/ is the trap.
+	if c.results.len() != 4 {
+		t.Errorf("crawled %d pages; want 4", c.results.len())
+	}
+	traps := c.traps.traps()
+	if len(traps) != 1 || traps[0].Kind != trapRepeatedSegments || traps[0].URLs[0] != server.URL+"/a/a/a/a/" {
+		t.Errorf("traps = %+v; want the repeated /a/ segment", traps)
+	}
+}
+
+func TestCrawl_TrapBudgetSkipsSeenPages(t *testing.T) {
+	// Every page links to all the items; only the first link to each one
+	// may count against the /item/* budget.
+	links := []string{"/item/1", "/item/2", "/item/3"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintln(w, createHTML("Page", links))
+	}))
+	defer server.Close()
+
+	c := newConfig([]string{server.URL}, 1, 100)
+	c.traps = newTrapDetector(TrapLimits{PatternBudget: 3})
+	c.crawl(context.Background())
+
+	if c.results.len() != 4 {
+		t.Errorf("crawled %d pages; want the home page and 3 items", c.results.len())
+	}
+	if traps := c.traps.traps(); len(traps) != 0 {
+		t.Errorf("traps = %+v; want none", traps)
+	}
+}
@@ -0,0 +1,376 @@
+package crawler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	warcVersion    = "WARC/1.1"
+	warcConformsTo = "http://iipc.github.io/warc-specifications/specifications/warc-format/warc-1.1/"
+	warcDateFormat = "2006-01-02T15:04:05Z"
+
+	// DefaultWARCMaxSize is the size at which a WARC file is closed and
+	// the next one started, as recommended by the WARC specification.
+	DefaultWARCMaxSize = 1 << 30
+)
+
+// warcField is one named header of a WARC record or line of a warcinfo
+// block. Fields keep their order, unlike an http.Header.
+type warcField struct {
+	name  string
+	value string
+}
+
+// warcWriter writes records to a series of gzipped WARC files in dir,
+// each record its own gzip member so readers can seek to any of them.
+// Once a file reaches maxSize the next record starts a new file, which
+// opens with its own warcinfo record. It is safe for concurrent use.
+type warcWriter struct {
+	dir     string
+	prefix  string
+	maxSize int64
+	info    []warcField // contents of every warcinfo record
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	serial int
+	infoID string   // record ID of the current file's warcinfo
+	files  []string // paths of every file written, in order
+}
+
+func newWARCWriter(dir, prefix string, maxSize int64, info []warcField) (*warcWriter, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &warcWriter{dir: dir, prefix: prefix, maxSize: maxSize, info: info}, nil
+}
+
+// write stores a record of the given type. The record ID, date and
+// length fields are filled in; fields adds the type-specific ones. It
+// returns the new record's ID.
+func (w *warcWriter) write(recordType string, date time.Time, fields []warcField, block []byte) (string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		if err := w.rotate(date); err != nil {
+			return "", err
+		}
+	}
+
+	id := newRecordID()
+	header := []warcField{
+		{"WARC-Type", recordType},
+		{"WARC-Record-ID", id},
+		{"WARC-Date", date.UTC().Format(warcDateFormat)},
+		{"WARC-Warcinfo-ID", w.infoID},
+	}
+	if err := w.writeRecord(append(header, fields...), block); err != nil {
+		return "", err
+	}
+
+	if w.maxSize > 0 && w.size >= w.maxSize {
+		if err := w.file.Close(); err != nil {
+			return id, err
+		}
+		w.file = nil
+	}
+	return id, nil
+}
+
+// rotate starts the next file and writes its warcinfo record.
+func (w *warcWriter) rotate(date time.Time) error {
+	name := fmt.Sprintf("%s-%s-%05d.warc.gz", w.prefix, date.UTC().Format("20060102150405"), w.serial)
+	path := filepath.Join(w.dir, name)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	w.file = file
+	w.size = 0
+	w.serial++
+	w.files = append(w.files, path)
+
+	var block bytes.Buffer
+	for _, f := range w.info {
+		fmt.Fprintf(&block, "%s: %s\r\n", f.name, f.value)
+	}
+	w.infoID = newRecordID()
+	return w.writeRecord([]warcField{
+		{"WARC-Type", "warcinfo"},
+		{"WARC-Record-ID", w.infoID},
+		{"WARC-Date", date.UTC().Format(warcDateFormat)},
+		{"WARC-Filename", name},
+		{"Content-Type", "application/warc-fields"},
+	}, block.Bytes())
+}
+
+// writeRecord compresses one record into its own gzip member and appends
+// it to the current file.
+func (w *warcWriter) writeRecord(fields []warcField, block []byte) error {
+	var record bytes.Buffer
+	zw := gzip.NewWriter(&record)
+	fmt.Fprintf(zw, "%s\r\n", warcVersion)
+	for _, f := range fields {
+		fmt.Fprintf(zw, "%s: %s\r\n", f.name, f.value)
+	}
+	fmt.Fprintf(zw, "Content-Length: %d\r\n\r\n", len(block))
+	zw.Write(block)
+	io.WriteString(zw, "\r\n\r\n")
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	n, err := w.file.Write(record.Bytes())
+	w.size += int64(n)
+	return err
+}
+
+func (w *warcWriter) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// newRecordID returns a random (version 4) UUID URN.
+func newRecordID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// warcDigest is the SHA-1 digest format used by WARC-Block-Digest and
+// WARC-Payload-Digest.
+func warcDigest(data []byte) string {
+	sum := sha1.Sum(data)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+// warcTransport is an http.RoundTripper that archives every exchange it
+// carries, redirects included, as a request and a response record. A
+// request that gets no response is archived as a request and a metadata
+// record holding the error.
+//
+// Response bodies are archived as they came off the wire, still content
+// encoded but with any chunked transfer coding removed, up to maxBody
+// bytes. A body the crawler skipped or stopped reading early, such as an
+// error page, is read on to the end or to maxBody when it is closed, so
+// the archive holds it all the same.
+type warcTransport struct {
+	next    http.RoundTripper
+	w       *warcWriter
+	maxBody int64        // 0 for no limit
+	logger  *slog.Logger // nil to drop errors writing records
+}
+
+// logError reports a record that could not be archived. The fetch itself
+// goes on regardless.
+func (t *warcTransport) logError(req *http.Request, err error) {
+	if t.logger != nil {
+		t.logger.Error("error writing WARC record", "url", req.URL.String(), "error", err)
+	}
+}
+
+func (t *warcTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	date := time.Now()
+	res, err := next.RoundTrip(req)
+	if err != nil {
+		t.archiveFailure(req, date, err)
+		return nil, err
+	}
+	res.Body = &warcBody{ReadCloser: res.Body, t: t, req: req, res: res, date: date}
+	return res, nil
+}
+
+func (t *warcTransport) archiveRequest(req *http.Request, date time.Time, concurrentTo string) (string, error) {
+	block := httpRequestBlock(req)
+	fields := []warcField{
+		{"WARC-Target-URI", req.URL.String()},
+		{"Content-Type", "application/http;msgtype=request"},
+		{"WARC-Block-Digest", warcDigest(block)},
+	}
+	if concurrentTo != "" {
+		fields = append(fields, warcField{"WARC-Concurrent-To", concurrentTo})
+	}
+	return t.w.write("request", date, fields, block)
+}
+
+func (t *warcTransport) archiveFailure(req *http.Request, date time.Time, fetchErr error) {
+	requestID, err := t.archiveRequest(req, date, "")
+	if err == nil {
+		_, err = t.w.write("metadata", date, []warcField{
+			{"WARC-Target-URI", req.URL.String()},
+			{"WARC-Concurrent-To", requestID},
+			{"Content-Type", "application/warc-fields"},
+		}, []byte("fetch-error: "+fetchErr.Error()+"\r\n"))
+	}
+	if err != nil {
+		t.logError(req, err)
+	}
+}
+
+// warcBody keeps a copy of a response body as it is read and archives
+// the exchange when the body is closed.
+type warcBody struct {
+	io.ReadCloser
+	t         *warcTransport
+	req       *http.Request
+	res       *http.Response
+	date      time.Time
+	payload   bytes.Buffer
+	truncated bool // payload was cut at maxBody
+	eof       bool // the whole body was read
+	closed    bool
+}
+
+func (b *warcBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.keep(p[:n])
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+func (b *warcBody) keep(data []byte) {
+	if b.t.maxBody > 0 && int64(b.payload.Len())+int64(len(data)) > b.t.maxBody {
+		data = data[:b.t.maxBody-int64(b.payload.Len())]
+		b.truncated = true
+	}
+	b.payload.Write(data)
+}
+
+func (b *warcBody) Close() error {
+	if b.closed {
+		return b.ReadCloser.Close()
+	}
+	b.closed = true
+	b.drain()
+	if err := b.archive(); err != nil {
+		b.t.logError(b.req, err)
+	}
+	return b.ReadCloser.Close()
+}
+
+// drain reads what the crawler left of the body, up to one byte past
+// maxBody so that a longer body is known to be truncated. A body that
+// fails to read is archived as far as it got.
+func (b *warcBody) drain() {
+	if b.eof || b.truncated {
+		return
+	}
+	var r io.Reader = b
+	if b.t.maxBody > 0 {
+		r = io.LimitReader(b, b.t.maxBody-int64(b.payload.Len())+1)
+	}
+	io.Copy(io.Discard, r)
+}
+
+func (b *warcBody) archive() error {
+	payload := b.payload.Bytes()
+	block := append(httpResponseHead(b.res), payload...)
+
+	fields := []warcField{
+		{"WARC-Target-URI", b.req.URL.String()},
+		{"Content-Type", "application/http;msgtype=response"},
+		{"WARC-Block-Digest", warcDigest(block)},
+		{"WARC-Payload-Digest", warcDigest(payload)},
+	}
+	complete := b.eof || int64(len(payload)) == b.res.ContentLength
+	switch {
+	case b.truncated:
+		fields = append(fields, warcField{"WARC-Truncated", "length"})
+	case !complete:
+		fields = append(fields, warcField{"WARC-Truncated", "unspecified"})
+	}
+	responseID, err := b.t.w.write("response", b.date, fields, block)
+	if err != nil {
+		return err
+	}
+	_, err = b.t.archiveRequest(b.req, b.date, responseID)
+	return err
+}
+
+// httpRequestBlock renders the request head the way it went out.
+func httpRequestBlock(req *http.Request) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s HTTP/1.1\r\n", req.Method, req.URL.RequestURI())
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	fmt.Fprintf(&b, "Host: %s\r\n", host)
+	header := req.Header.Clone()
+	if header.Get("User-Agent") == "" {
+		header.Set("User-Agent", "Go-http-client/1.1")
+	}
+	header.Write(&b)
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+// httpResponseHead renders the status line and headers of a response.
+// The transport has already removed chunked transfer coding from the
+// body, so no Transfer-Encoding header is written.
+func httpResponseHead(res *http.Response) []byte {
+	var b bytes.Buffer
+	proto := res.Proto
+	if proto == "" {
+		proto = "HTTP/1.1"
+	}
+	fmt.Fprintf(&b, "%s %s\r\n", proto, res.Status)
+	res.Header.Write(&b)
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+// warcInfo describes the crawl configuration for warcinfo records.
+func (cfg *config) warcInfo() []warcField {
+	info := []warcField{
+		{"software", "crawler"},
+		{"format", "WARC File Format 1.1"},
+		{"conformsTo", warcConformsTo},
+	}
+	hostname, err := os.Hostname()
+	if err == nil {
+		info = append(info, warcField{"hostname", hostname})
+	}
+	seeds := append([]string{}, cfg.seeds...)
+	sort.Strings(seeds)
+	for _, seed := range seeds {
+		info = append(info, warcField{"seed", seed})
+	}
+	return append(info,
+		warcField{"max-concurrency", strconv.Itoa(cfg.maxConcurrency)},
+		warcField{"max-pages", strconv.Itoa(cfg.maxPages)},
+		warcField{"max-body-size", strconv.FormatInt(cfg.limits.maxBodySize, 10)},
+		warcField{"ignore-robots-meta", strconv.FormatBool(cfg.ignoreRobotsMeta)},
+	)
+}
@@ -0,0 +1,239 @@
+package crawler
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var errNotArchived = errors.New("not found in the WARC archive")
+
+// countingReader counts the bytes read through it, so record offsets
+// can be worked out.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// readWARCHeader reads the version line and named fields of a record
+// and returns a reader for its block.
+func readWARCHeader(r *bufio.Reader) (textproto.MIMEHeader, io.Reader, error) {
+	version, err := r.ReadString('\n')
+	if err != nil {
+		return nil, nil, err
+	}
+	if !strings.HasPrefix(version, "WARC/") {
+		return nil, nil, fmt.Errorf("not a WARC record: %q", strings.TrimSpace(version))
+	}
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		return nil, nil, err
+	}
+	length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid record length: %w", err)
+	}
+	return header, io.LimitReader(r, length), nil
+}
+
+// scanWARC calls fn with the offset and header of every record in a WARC
+// file, gzipped record by record or not compressed at all. fn may read
+// the block; whatever it leaves is skipped.
+func scanWARC(path string, fn func(offset int64, header textproto.MIMEHeader, block io.Reader) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	counter := &countingReader{r: file}
+	// bufio.Reader is an io.ByteReader, so gzip reads no further than
+	// the end of each member.
+	br := bufio.NewReader(counter)
+	magic, _ := br.Peek(2)
+	compressed := bytes.Equal(magic, []byte{0x1f, 0x8b})
+
+	var zr *gzip.Reader
+	for {
+		if _, err := br.Peek(1); err == io.EOF {
+			return nil
+		}
+		offset := counter.n - int64(br.Buffered())
+
+		r := br
+		if compressed {
+			if zr == nil {
+				zr, err = gzip.NewReader(br)
+			} else {
+				err = zr.Reset(br)
+			}
+			if err != nil {
+				return fmt.Errorf("%s at offset %d: %w", path, offset, err)
+			}
+			zr.Multistream(false)
+			r = bufio.NewReader(zr)
+		}
+
+		header, block, err := readWARCHeader(r)
+		if err != nil {
+			return fmt.Errorf("%s at offset %d: %w", path, offset, err)
+		}
+		if err := fn(offset, header, block); err != nil {
+			return err
+		}
+		if _, err := io.Copy(io.Discard, block); err != nil {
+			return err
+		}
+
+		// Skip the blank lines closing the record.
+		if compressed {
+			_, err = io.Copy(io.Discard, r)
+		} else {
+			_, err = r.Discard(4)
+		}
+		if err != nil {
+			return fmt.Errorf("%s at offset %d: %w", path, offset, err)
+		}
+	}
+}
+
+// readWARCRecord reads the single record starting at offset.
+func readWARCRecord(path string, offset int64) (textproto.MIMEHeader, []byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+
+	br := bufio.NewReader(file)
+	magic, _ := br.Peek(2)
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		zr.Multistream(false)
+		br = bufio.NewReader(zr)
+	}
+
+	header, block, err := readWARCHeader(br)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := io.ReadAll(block)
+	return header, data, err
+}
+
+// archivedRecord locates the record answering for one URL.
+type archivedRecord struct {
+	path      string
+	offset    int64
+	fetchErr  string // set for a metadata record of a failed request
+	truncated bool
+}
+
+// replayTransport is an http.RoundTripper that answers requests from
+// WARC files instead of the network, so a recorded crawl can be run
+// through crawlPage again. Only an index of record offsets is kept in
+// memory; records are read back when requested. When a URL was archived
+// more than once, the last capture wins.
+type replayTransport struct {
+	records map[string]archivedRecord // by target URI, read-only once built
+}
+
+// newReplayTransport indexes the WARC files at path, a single file or a
+// directory of .warc and .warc.gz files.
+func newReplayTransport(path string) (*replayTransport, error) {
+	paths := []string{path}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		paths = nil
+		for _, pattern := range []string{"*.warc", "*.warc.gz"} {
+			matches, err := filepath.Glob(filepath.Join(path, pattern))
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, matches...)
+		}
+		// File names carry their creation time and serial number.
+		sort.Strings(paths)
+		if len(paths) == 0 {
+			return nil, fmt.Errorf("no WARC files in %s", path)
+		}
+	}
+
+	t := &replayTransport{records: map[string]archivedRecord{}}
+	for _, p := range paths {
+		err := scanWARC(p, func(offset int64, header textproto.MIMEHeader, block io.Reader) error {
+			uri := header.Get("WARC-Target-URI")
+			switch header.Get("WARC-Type") {
+			case "response":
+				t.records[uri] = archivedRecord{path: p, offset: offset, truncated: header.Get("WARC-Truncated") != ""}
+			case "metadata":
+				fields, err := io.ReadAll(block)
+				if err != nil {
+					return err
+				}
+				for _, line := range strings.Split(string(fields), "\r\n") {
+					if message, ok := strings.CutPrefix(line, "fetch-error: "); ok {
+						t.records[uri] = archivedRecord{path: p, offset: offset, fetchErr: message}
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	record, ok := t.records[req.URL.String()]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", req.URL, errNotArchived)
+	}
+	if record.fetchErr != "" {
+		return nil, errors.New(record.fetchErr)
+	}
+
+	_, block, err := readWARCRecord(record.path, record.offset)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(bytes.NewReader(block))
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, fmt.Errorf("archived response for %s: %w", req.URL, err)
+	}
+	// The body is the rest of the block, however its headers frame it:
+	// a truncated body is shorter than its Content-Length says.
+	res.Body = io.NopCloser(br)
+	if record.truncated {
+		res.ContentLength = -1
+	}
+	return res, nil
+}
@@ -0,0 +1,105 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReplayTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintln(w, createHTML("Home", []string{"/old", "/missing", "/zipped", "http://127.0.0.1:1/down"}))
+		case "/old":
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+		case "/new":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintln(w, createHTML("New", []string{"/"}))
+		case "/zipped":
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(gzipBytes(t, []byte(createHTML("Zipped", nil))))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	dir := t.TempDir()
+	live := newConfig([]string{server.URL}, 1, 100)
+	warc, err := newWARCWriter(dir, "live", DefaultWARCMaxSize, live.warcInfo())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	live.fetcher = &HTTPFetcher{Transport: &warcTransport{w: warc, maxBody: live.limits.maxBodySize}}
+	live.crawl(context.Background())
+	warc.close()
+	// From here on only the archive can answer.
+	server.Close()
+
+	replay, err := newReplayTransport(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	replayed := newConfig([]string{server.URL}, 1, 100)
+	replayed.fetcher = &HTTPFetcher{Transport: replay}
+	replayed.crawl(context.Background())
+
+	if replayed.results.len() != live.results.len() {
+		t.Fatalf("replay stored %d pages; want %d", replayed.results.len(), live.results.len())
+	}
+	live.results.each(func(key string, want *Page) bool {
+		t.Run(key, func(t *testing.T) {
+			got, ok := replayed.result(key)
+			if !ok {
+				t.Fatalf("page missing from the replay")
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("replayed page = %+v; want %+v", got, want)
+			}
+		})
+		return true
+	})
+
+	t.Run("Not archived", func(t *testing.T) {
+		_, err := fetchPage(context.Background(), server.URL+"/never", defaultFetchLimits, validators{}, &HTTPFetcher{Transport: replay})
+		if !errors.Is(err, errNotArchived) {
+			t.Errorf("error = %v; want errNotArchived", err)
+		}
+	})
+}
+
+func TestScanWARC_Uncompressed(t *testing.T) {
+	body := "HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nContent-Length: 5\r\n\r\nhello"
+	record := func(uri, block string) string {
+		return "WARC/1.1\r\nWARC-Type: response\r\nWARC-Target-URI: " + uri +
+			fmt.Sprintf("\r\nContent-Length: %d\r\n\r\n", len(block)) + block + "\r\n\r\n"
+	}
+	path := filepath.Join(t.TempDir(), "plain.warc")
+	data := record("https://example.com/a", body) + record("https://example.com/b", strings.Replace(body, "hello", "world", 1))
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	replay, err := newReplayTransport(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for uri, want := range map[string]string{"https://example.com/a": "hello", "https://example.com/b": "world"} {
+		res, err := fetchPage(context.Background(), uri, defaultFetchLimits, validators{}, &HTTPFetcher{Transport: replay})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if body := readFetched(t, res); body != want {
+			t.Errorf("body of %s = %q; want %q", uri, body, want)
+		}
+	}
+}
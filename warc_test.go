@@ -0,0 +1,286 @@
+package crawler
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testWARCRecord struct {
+	header map[string]string
+	block  []byte
+}
+
+// readTestWARC reads a gzipped WARC file, checking that every record is
+// its own gzip member.
+func readTestWARC(t *testing.T, path string) []testWARCRecord {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := []testWARCRecord{}
+	br := bufio.NewReader(bytes.NewReader(data))
+	for {
+		if _, err := br.Peek(1); err == io.EOF {
+			return records
+		}
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			t.Fatalf("record %d: %v", len(records), err)
+		}
+		zr.Multistream(false)
+		member, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("record %d: %v", len(records), err)
+		}
+
+		head, rest, ok := bytes.Cut(member, []byte("\r\n\r\n"))
+		if !ok {
+			t.Fatalf("record %d has no header end", len(records))
+		}
+		lines := strings.Split(string(head), "\r\n")
+		if lines[0] != warcVersion {
+			t.Fatalf("record %d starts with %q", len(records), lines[0])
+		}
+		record := testWARCRecord{header: map[string]string{}}
+		for _, line := range lines[1:] {
+			name, value, _ := strings.Cut(line, ": ")
+			record.header[name] = value
+		}
+		length, err := strconv.Atoi(record.header["Content-Length"])
+		if err != nil || len(rest) != length+4 || !bytes.HasSuffix(rest, []byte("\r\n\r\n")) {
+			t.Fatalf("record %d: block of %d bytes does not match Content-Length %q", len(records), len(rest), record.header["Content-Length"])
+		}
+		record.block = rest[:length]
+		records = append(records, record)
+	}
+}
+
+func TestWARCTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			// Port 1 is on the same host, so it is crawled, and refuses
+			// connections.
+			fmt.Fprintln(w, createHTML("Home", []string{"/old", "/missing", "http://127.0.0.1:1/down"}))
+		case "/old":
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+		case "/new":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintln(w, createHTML("New", nil))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	c := newConfig([]string{server.URL}, 1, 100)
+	warc, err := newWARCWriter(dir, "test", DefaultWARCMaxSize, c.warcInfo())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.fetcher = &HTTPFetcher{Transport: &warcTransport{w: warc, maxBody: c.limits.maxBodySize}}
+	c.crawl(context.Background())
+	if err := warc.close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(warc.files) != 1 {
+		t.Fatalf("wrote %d files; want 1", len(warc.files))
+	}
+	records := readTestWARC(t, warc.files[0])
+
+	info := records[0]
+	if info.header["WARC-Type"] != "warcinfo" || !bytes.Contains(info.block, []byte("seed: "+server.URL+"\r\n")) {
+		t.Errorf("first record = %v %q; want a warcinfo record listing the seed", info.header, info.block)
+	}
+
+	responses := map[string]testWARCRecord{}
+	requests := map[string]testWARCRecord{}
+	metadata := map[string]testWARCRecord{}
+	for _, r := range records[1:] {
+		if r.header["WARC-Warcinfo-ID"] != info.header["WARC-Record-ID"] {
+			t.Errorf("record %s does not refer to the warcinfo record", r.header["WARC-Record-ID"])
+		}
+		if digest := warcDigest(r.block); r.header["WARC-Block-Digest"] != "" && r.header["WARC-Block-Digest"] != digest {
+			t.Errorf("block digest of %s = %s; want %s", r.header["WARC-Target-URI"], r.header["WARC-Block-Digest"], digest)
+		}
+		uri := strings.TrimPrefix(r.header["WARC-Target-URI"], server.URL)
+		switch r.header["WARC-Type"] {
+		case "response":
+			responses[uri] = r
+		case "request":
+			requests[uri] = r
+		case "metadata":
+			metadata[uri] = r
+		}
+	}
+
+	tests := []struct {
+		uri        string
+		statusLine string
+		body       string
+		truncated  string
+	}{
+		{"", "HTTP/1.1 200 OK", "<title>Home</title>", ""},
+		{"/old", "HTTP/1.1 301 Moved Permanently", "", ""},
+		{"/new", "HTTP/1.1 200 OK", "<title>New</title>", ""},
+		// Error pages are not read by the crawler but archived whole.
+		{"/missing", "HTTP/1.1 404 Not Found", "404 page not found", ""},
+	}
+	for _, tc := range tests {
+		t.Run("Response "+tc.uri, func(t *testing.T) {
+			res, ok := responses[tc.uri]
+			if !ok {
+				t.Fatalf("no response record")
+			}
+			if !bytes.HasPrefix(res.block, []byte(tc.statusLine+"\r\n")) || !bytes.Contains(res.block, []byte(tc.body)) {
+				t.Errorf("response block = %q; want %q and %q", res.block, tc.statusLine, tc.body)
+			}
+			if got := res.header["WARC-Truncated"]; got != tc.truncated {
+				t.Errorf("WARC-Truncated = %q; want %q", got, tc.truncated)
+			}
+			req, ok := requests[tc.uri]
+			if !ok || req.header["WARC-Concurrent-To"] != res.header["WARC-Record-ID"] {
+				t.Errorf("request record = %v; want one concurrent to the response", req.header)
+			}
+		})
+	}
+
+	t.Run("Failure", func(t *testing.T) {
+		down := "http://127.0.0.1:1/down"
+		meta, ok := metadata[down]
+		if !ok || !bytes.HasPrefix(meta.block, []byte("fetch-error: ")) {
+			t.Fatalf("metadata record = %v %q; want a fetch error", meta.header, meta.block)
+		}
+		if req, ok := requests[down]; !ok || meta.header["WARC-Concurrent-To"] != req.header["WARC-Record-ID"] {
+			t.Errorf("metadata record does not refer to the request record")
+		}
+	})
+}
+
+func TestWARCWriter_Rotation(t *testing.T) {
+	dir := t.TempDir()
+	w, err := newWARCWriter(dir, "rotate", 1, []warcField{{"software", "crawler"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := range 3 {
+		if _, err := w.write("resource", time.Now(), nil, []byte(fmt.Sprintf("record %d", i))); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := w.close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "rotate-*.warc.gz"))
+	if len(files) != 3 || len(w.files) != 3 {
+		t.Fatalf("wrote files %v; want 3", files)
+	}
+	for i, path := range w.files {
+		records := readTestWARC(t, path)
+		if len(records) != 2 || records[0].header["WARC-Type"] != "warcinfo" ||
+			records[0].header["WARC-Filename"] != filepath.Base(path) {
+			t.Errorf("file %d holds %d records starting with %v; want warcinfo and one record", i, len(records), records[0].header)
+		}
+		if want := fmt.Sprintf("record %d", i); string(records[1].block) != want {
+			t.Errorf("file %d record = %q; want %q", i, records[1].block, want)
+		}
+	}
+}
+
+func TestWARCTransport_TruncatesLongBodies(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		if r.URL.Path == "/short" {
+			io.WriteString(w, "short")
+			return
+		}
+		io.WriteString(w, strings.Repeat("x", 100))
+	}))
+	defer server.Close()
+
+	w, err := newWARCWriter(t.TempDir(), "trunc", 0, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client := &http.Client{Transport: &warcTransport{w: w, maxBody: 10}}
+	get := func(path string, read int64) {
+		res, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		io.CopyN(io.Discard, res.Body, read)
+		res.Body.Close()
+	}
+	get("/", 20)
+	// Nothing is read: closing reads the body for the archive.
+	get("/", 0)
+	get("/short", 0)
+	w.close()
+
+	records := readTestWARC(t, w.files[0])
+	for _, res := range []testWARCRecord{records[1], records[3]} {
+		if res.header["WARC-Type"] != "response" || res.header["WARC-Truncated"] != "length" ||
+			!bytes.HasSuffix(res.block, []byte("\r\n\r\n"+strings.Repeat("x", 10))) {
+			t.Errorf("response record = %v %q; want a body truncated to 10 bytes", res.header, res.block)
+		}
+		if res.header["WARC-Payload-Digest"] != warcDigest([]byte(strings.Repeat("x", 10))) {
+			t.Errorf("payload digest does not match the archived payload")
+		}
+	}
+	short := records[5]
+	if short.header["WARC-Type"] != "response" || short.header["WARC-Truncated"] != "" ||
+		!bytes.HasSuffix(short.block, []byte("\r\n\r\nshort")) {
+		t.Errorf("response record = %v %q; want the whole short body", short.header, short.block)
+	}
+}
+
+func TestNew_WARCInfoListsRestoredSeeds(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintln(w, createHTML("Home", nil))
+	}))
+	defer server.Close()
+
+	// A checkpoint taken before anything was fetched.
+	stateDir := t.TempDir()
+	if err := newConfig([]string{server.URL}, 1, 100).saveCheckpoint(stateDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	warcDir := t.TempDir()
+	c, err := New(nil, WithResume(stateDir), WithWARC(warcDir, "resumed", DefaultWARCMaxSize))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := c.Run(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.Close()
+
+	files, _ := filepath.Glob(filepath.Join(warcDir, "resumed-*.warc.gz"))
+	if len(files) != 1 {
+		t.Fatalf("wrote files %v; want 1", files)
+	}
+	info := readTestWARC(t, files[0])[0]
+	if !bytes.Contains(info.block, []byte("seed: "+server.URL+"\r\n")) {
+		t.Errorf("warcinfo = %q; want the restored seed", info.block)
+	}
+}